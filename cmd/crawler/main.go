@@ -1,28 +1,99 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/integralist/go-web-crawler/internal/cache"
+	"github.com/integralist/go-web-crawler/internal/checker"
 	"github.com/integralist/go-web-crawler/internal/coordinator"
 	"github.com/integralist/go-web-crawler/internal/crawler"
+	"github.com/integralist/go-web-crawler/internal/diff"
+	"github.com/integralist/go-web-crawler/internal/formatter"
+	"github.com/integralist/go-web-crawler/internal/frontier"
 	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/limiter"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/normalizer"
 	"github.com/integralist/go-web-crawler/internal/parser"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/robots"
+	sitemaps "github.com/integralist/go-web-crawler/internal/sitemap"
+	"github.com/integralist/go-web-crawler/internal/state"
 	"github.com/sirupsen/logrus"
 )
 
 // instr contains pre-configured instrumentation tools
 var instr instrumentator.Instr
 
+// patterns is a flag.Value that can be specified multiple times.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
 var (
-	dot        *bool
-	hostname   string
-	httponly   *bool
-	json       *bool
-	subdomains string
-	version    string // set via -ldflags in Makefile
+	burst        *int
+	cachePath    *string
+	check        *bool
+	csvDir       *string
+	dot          *bool
+	dotCollapse  *bool
+	dotDepth     *int
+	dotPrefix    *string
+	dropParams   *string
+	gexf         *bool
+	graphml      *bool
+	hostInFlight *int
+	hostname     string
+	httponly     *bool
+	ignoreRobots *bool
+	includes     patterns
+	excludes     patterns
+	json         *bool
+	matchQuery   *bool
+	maxBytes     *int64
+	maxDepth     *int
+	maxDuration  *time.Duration
+	maxPages     *int
+	maxRedirects *int
+	ndjson       *bool
+	order        *string
+	query        *string
+	resume       *bool
+	rate         *float64
+	report       *string
+	seedSitemaps *bool
+	sitemap      *bool
+	sitemapBase  *string
+	sitemapDir   *string
+	sitemapGzip  *bool
+	stateDir     *string
+	retries      *int
+	retryBase    *time.Duration
+	retryJitter  *float64
+	retryMax     *time.Duration
+	retryStatus  *string
+	robotsMeta   *string
+	scanCSS      *bool
+	subdomains   string
+	trailSlash   *string
+	version      string // set via -ldflags in Makefile
 )
 
 func init() {
@@ -33,9 +104,49 @@ func init() {
 	logrus.SetReportCaller(true) // TODO: benchmark for performance implications
 
 	// flag configuration
+	burst = flag.Int("burst", 5, "number of requests a host can receive in a burst")
+	cachePath = flag.String("cache", "", "file used to cache pages between crawls, so that unchanged pages aren't downloaded again")
+	check = flag.Bool("check", false, "check every discovered URL (including external links and assets) and report those that are broken")
+	csvDir = flag.String("csv", "", "writes the link graph as nodes.csv and edges.csv files to the given directory")
 	dot = flag.Bool("dot", false, "returns dot format file for use with graphviz")
+	dotCollapse = flag.Bool("dot-collapse", false, "merge the -dot edges from pages in the same cluster to the same target into a single edge")
+	dotDepth = flag.Int("dot-depth", 0, "only include pages at most this many clicks from the entry page in the -dot output (0 for no limit)")
+	dotPrefix = flag.String("dot-prefix", "", "only include pages whose path starts with this prefix in the -dot output")
+	dropParams = flag.String("drop-params", strings.Join(normalizer.DefaultConfig.DropParams, ","), "comma separated query parameters removed by -query drop-listed (a trailing * matches a prefix)")
+	gexf = flag.Bool("gexf", false, "returns the link graph in GEXF format for use with Gephi")
+	graphml = flag.Bool("graphml", false, "returns the link graph in GraphML format for use with Gephi or Cytoscape")
+	hostInFlight = flag.Int("host-inflight", 5, "maximum concurrent requests per host (0 for no limit)")
 	httponly = flag.Bool("httponly", false, "indicates HTTPS vs HTTP")
+	ignoreRobots = flag.Bool("ignore-robots", false, "crawl URLs even if robots.txt disallows them")
+	flag.Var(&excludes, "exclude", "skip URLs whose path matches the glob `pattern` (prefix with re: for a regular expression), can be repeated")
+	flag.Var(&includes, "include", "only crawl URLs whose path matches the glob `pattern` (prefix with re: for a regular expression), can be repeated")
 	json = flag.Bool("json", false, "returns raw site structure JSON for the output")
+	matchQuery = flag.Bool("match-query", false, "match -include/-exclude patterns against the query string as well as the path")
+	maxBytes = flag.Int64("max-bytes", 0, "stop crawling once this many bytes have been downloaded (0 for no limit)")
+	maxDepth = flag.Int("max-depth", coordinator.NoMaxDepth, "maximum click depth from the entry page (0 for only the entry page, -1 for no limit)")
+	maxDuration = flag.Duration("max-duration", 0, "stop crawling after this amount of time (0 for no limit)")
+	maxPages = flag.Int("max-pages", 0, "stop crawling once this many pages have been requested (0 for no limit)")
+	maxRedirects = flag.Int("max-redirects", requester.DefaultMaxRedirects, "maximum number of redirects to follow for a single URL")
+	ndjson = flag.Bool("ndjson", false, "streams each crawled page as a line of JSON as soon as it's processed, followed by a summary line")
+	order = flag.String("order", string(frontier.BFS), "crawl order for discovered URLs (bfs or dfs)")
+	query = flag.String("query", string(normalizer.DefaultConfig.Query), "query string handling when normalizing URLs (keep, drop or drop-listed)")
+	rate = flag.Float64("rate", 10, "maximum requests per second per host (0 for no limit)")
+	report = flag.String("report", "", "writes an interactive HTML report (a single self-contained file) to the given path")
+	resume = flag.Bool("resume", false, "resume the crawl persisted to -state-dir (if any) rather than starting again")
+	retries = flag.Int("retries", requester.DefaultRetryPolicy.MaxAttempts, "maximum number of attempts per URL")
+	retryBase = flag.Duration("retry-base", requester.DefaultRetryPolicy.BaseDelay, "initial backoff delay between attempts")
+	retryJitter = flag.Float64("retry-jitter", requester.DefaultRetryPolicy.Jitter, "fraction (0-1) of the backoff delay to randomize")
+	retryMax = flag.Duration("retry-max", requester.DefaultRetryPolicy.MaxDelay, "maximum backoff delay between attempts")
+	retryStatus = flag.String("retry-status", "429,502,503,504", "comma separated list of status codes to retry")
+	robotsMeta = flag.String("robots-meta", string(crawler.RobotsMetaReport), "what to do about nofollow links, robots <meta> elements and X-Robots-Tag headers (obey or report)")
+	scanCSS = flag.Bool("scan-css", false, "request same-site stylesheets to find the resources they reference (e.g. fonts and background images), which aren't covered by -max-pages or -max-bytes")
+	seedSitemaps = flag.Bool("seed-sitemaps", false, "also crawl the URLs listed in the site's sitemaps (and report orphan pages)")
+	sitemap = flag.Bool("sitemap", false, "writes a sitemap.xml file for the crawled pages")
+	sitemapBase = flag.String("sitemap-base", "", "URL the sitemap files are served from (defaults to the host the entry page was served from)")
+	sitemapDir = flag.String("sitemap-dir", ".", "directory the sitemap files are written to")
+	sitemapGzip = flag.Bool("sitemap-gzip", false, "gzip compress the sitemap files")
+	stateDir = flag.String("state-dir", "", "directory the crawl's progress is persisted to, so it can be resumed")
+	trailSlash = flag.String("trailing-slash", string(normalizer.DefaultConfig.TrailingSlash), "trailing slash handling when normalizing URLs (keep, add or strip)")
 	const (
 		flagHostnameValue   = "integralist.co.uk"
 		flagHostnameUsage   = "hostname to crawl"
@@ -48,6 +159,13 @@ func init() {
 	flag.StringVar(&subdomains, "s", flagSubdomainsValue, flagSubdomainsUsage+" (shorthand)")
 	flag.Parse()
 
+	// note: the machine readable output is written to stdout (and is often piped
+	// into another program, e.g. `-ndjson | jq`), and so the logs are written to
+	// stderr instead so the two don't get interleaved.
+	if *json || *ndjson || *dot || *graphml || *gexf {
+		logrus.SetOutput(os.Stderr)
+	}
+
 	// instrumentation configuration
 	//
 	// we would in a real-world application configure this with additional fields
@@ -78,25 +196,317 @@ func main() {
 	// surfacing all the _unexpected_ things that happened.
 	instr.Logger.Debug("STARTUP_SUCCESSFUL")
 
+	// note: flag parsing stops at the first non-flag argument, meaning the diff
+	// subcommand (and its own flags) are left for us to handle here.
+	if flag.Arg(0) == "diff" {
+		diffCommand(flag.Args()[1:])
+		return
+	}
+
 	protocol := "https"
 	if *httponly {
 		protocol = "http"
 	}
 
+	// every request (including retries, redirects and robots.txt) goes through
+	// the same per-host limiter so that we don't overwhelm the sites being
+	// crawled.
+	hostLimiter := limiter.New(limiter.Config{
+		Rate:        *rate,
+		Burst:       *burst,
+		MaxInFlight: *hostInFlight,
+	})
+
 	// the following http client configuration is passed around so that when we
 	// make multiple GET requests we don't have to recreate the net/http client.
-	httpClient := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+	//
+	// note: the requester records each redirect the client follows, while the
+	// CheckRedirect function limits how many are followed and detects loops.
+	politeClient := http.Client{
+		Timeout:       time.Duration(5 * time.Second),
+		CheckRedirect: requester.CheckRedirect(*maxRedirects),
+		Transport:     &limiter.Transport{Limiter: hostLimiter},
 	}
 
+	// a SIGINT/SIGTERM cancels the context, which stops the crawl gracefully so
+	// that we can still display the results gathered so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// after the first signal we restore the default behaviour, meaning a second
+	// Ctrl-C will kill the program immediately.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	// we will time how long our program takes to run.
 	startTime := time.Now()
 
+	retryableStatus, err := parseStatusCodes(*retryStatus)
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	if *ndjson && (*json || *dot || *graphml || *gexf) {
+		instr.Logger.Fatal("-ndjson can't be combined with -json, -dot, -graphml or -gexf")
+	}
+
+	crawlOrder, err := frontier.ParseOrder(*order)
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	robotsMetaBehaviour, err := crawler.ParseRobotsMeta(*robotsMeta)
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	trailingSlash, err := normalizer.ParseTrailingSlash(*trailSlash)
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	queryPolicy, err := normalizer.ParseQueryPolicy(*query)
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
 	// initialize our packages with the relevant configuration
-	crawler.Init(*json, *dot)
+	requester.Init(requester.RetryPolicy{
+		MaxAttempts:     *retries,
+		BaseDelay:       *retryBase,
+		MaxDelay:        *retryMax,
+		Jitter:          *retryJitter,
+		RetryableStatus: retryableStatus,
+	})
+	normalizer.Init(normalizer.Config{
+		TrailingSlash: trailingSlash,
+		Query:         queryPolicy,
+		DropParams:    strings.Split(*dropParams, ","),
+	})
 	parser.Init(protocol, hostname, subdomains)
+	if err := parser.InitFilters(includes, excludes, *matchQuery); err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	// robots.txt is fetched for every valid host up front, as the parser package
+	// is what determines which hosts we're allowed to crawl.
+	var robotsRules *robots.Robots
+	if !*ignoreRobots {
+		robotsRules = robots.Fetch(ctx, protocol, parser.ValidHosts, &politeClient, &instr)
+	}
+	for host := range parser.ValidHosts {
+		hostLimiter.SetCrawlDelay(host, robotsRules.CrawlDelay(host))
+	}
+
+	// note: the progress output is suppressed for any machine readable output.
+	crawler.Init(*json || *ndjson || *graphml || *gexf, *dot, robotsRules, robotsMetaBehaviour)
+
+	var seeds []string
+	if *seedSitemaps {
+		seeds = sitemaps.Discover(ctx, protocol, hostname, robotsRules, &politeClient, &instr)
+	}
+
+	// the crawl's progress is only persisted when a directory is given
+	var store *state.Store
+	if *stateDir != "" {
+		store, err = state.Open(*stateDir, *resume)
+		if err != nil {
+			instr.Logger.Fatal(err)
+		}
+	} else if *resume {
+		instr.Logger.Fatal("-resume requires -state-dir")
+	}
+
+	// the cache from the previous crawl is only used when a file is given
+	var previous *cache.Cache
+	if *cachePath != "" {
+		previous, err = cache.Load(*cachePath)
+		if err != nil {
+			instr.Logger.Fatal(err)
+		}
+	}
 
 	// trigger the coordinator to kick start the program
-	results := coordinator.Start(protocol, hostname, &httpClient, &instr)
-	coordinator.Results(results, *json, *dot, startTime)
+	limits := coordinator.Limits{
+		MaxDepth:    *maxDepth,
+		MaxPages:    *maxPages,
+		MaxDuration: *maxDuration,
+		MaxBytes:    *maxBytes,
+	}
+	// each page is written out as soon as it's processed when streaming
+	//
+	// note: onPage is never called concurrently, so it can count the pages.
+	var stream *formatter.NDJSON
+	var onPage func(mapper.Page)
+	var streamed int
+	if *ndjson {
+		stream = formatter.NewNDJSON(os.Stdout)
+		onPage = func(page mapper.Page) {
+			streamed++
+			stream.Page(page)
+		}
+	}
+
+	// a streamed page doesn't need to be kept in memory once it has been written,
+	// unless one of the outputs produced at the end of the crawl needs them all.
+	discard := *ndjson && !*check && !*sitemap && *csvDir == "" && *report == "" && !*seedSitemaps
+
+	// the stylesheets shared by the crawled pages are only requested once
+	var stylesheets *crawler.Stylesheets
+	if *scanCSS {
+		stylesheets = crawler.NewStylesheets(&politeClient, &instr)
+	}
+
+	results, summary := coordinator.Start(ctx, protocol, hostname, crawlOrder, limits, coordinator.Options{
+		Seeds:       seeds,
+		Store:       store,
+		Cache:       previous,
+		Stylesheets: stylesheets,
+		OnPage:      onPage,
+		Discard:     discard,
+	}, &politeClient, &instr)
+
+	if store != nil {
+		if err := store.Close(); err != nil {
+			instr.Logger.Warn(err)
+		}
+	}
+
+	if previous != nil {
+		// a crawl that ended early didn't get around to every page, and so rather
+		// than reporting the rest as removed (and forgetting them) we keep them.
+		if summary.StopReason != "" {
+			previous.KeepUnseen()
+		}
+
+		changes := previous.Changes()
+		summary.Changes = &changes
+
+		if err := previous.Save(*cachePath); err != nil {
+			instr.Logger.Warn(err)
+		}
+	}
+
+	if *check {
+		summary.Checked = true
+		summary.Broken = checker.Check(ctx, results, robotsRules, &politeClient, &instr)
+	}
+
+	if *sitemap {
+		// note: the sitemap must be served from the same host as the URLs it lists,
+		// which isn't necessarily the host we were given (e.g. the entry page of
+		// `integralist.co.uk` redirects to `www.integralist.co.uk`), and so we
+		// use the host the entry page was ultimately served from.
+		baseURL := *sitemapBase
+		if baseURL == "" && len(results) > 0 {
+			if u, err := url.Parse(results[0].URL); err == nil && u.Host != "" {
+				baseURL = fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+			}
+		}
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("%s://%s/", protocol, hostname)
+		}
+
+		files, err := formatter.Sitemap(results, formatter.SitemapConfig{
+			Dir:     *sitemapDir,
+			BaseURL: baseURL,
+			Gzip:    *sitemapGzip,
+		})
+		if err != nil {
+			instr.Logger.Fatal(err)
+		}
+		summary.Sitemaps = files
+	}
+
+	if *csvDir != "" {
+		files, err := formatter.CSV(results, *csvDir)
+		if err != nil {
+			instr.Logger.Fatal(err)
+		}
+		summary.CSV = files
+	}
+
+	// note: the report is written last so that it includes the rest of the
+	// summary (e.g. the broken links).
+	if *report != "" {
+		if err := formatter.Report(results, summary, *report); err != nil {
+			instr.Logger.Fatal(err)
+		}
+		summary.Report = *report
+	}
+
+	if stream != nil {
+		stream.Summary(streamed, summary, startTime)
+	} else {
+		dotConfig := formatter.DotConfig{
+			Collapse: *dotCollapse,
+			Prefix:   *dotPrefix,
+			Depth:    *dotDepth,
+		}
+		coordinator.Results(results, summary, *json, *dot, dotConfig, *graphml, *gexf, startTime)
+	}
+
+	// a non-zero exit code allows a CI pipeline to fail a build that introduces
+	// broken links.
+	if len(summary.Broken) > 0 {
+		os.Exit(1)
+	}
+}
+
+// diffCommand compares the -json output of two crawls.
+//
+// e.g. crawler diff [-json] old.json new.json
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "returns the differences as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff [-json] old.json new.json\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	old, err := diff.Load(flags.Arg(0))
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	new, err := diff.Load(flags.Arg(1))
+	if err != nil {
+		instr.Logger.Fatal(err)
+	}
+
+	d := diff.Compare(old, new)
+
+	if *asJSON {
+		fmt.Println(formatter.Pretty(d))
+		return
+	}
+	formatter.Diff(d)
+}
+
+// parseStatusCodes converts a comma separated list of status codes into a map.
+func parseStatusCodes(s string) (map[int]bool, error) {
+	codes := map[int]bool{}
+
+	for _, code := range strings.Split(s, ",") {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, err
+		}
+		codes[n] = true
+	}
+
+	return codes, nil
 }
@@ -1,5 +1,7 @@
 module github.com/integralist/go-web-crawler
 
+go 1.16
+
 require (
 	github.com/alecthomas/template v0.0.0-20160405071501-a0175ee3bccc
 	github.com/fatih/color v1.7.0
@@ -0,0 +1,240 @@
+package cache
+
+// The cache package remembers the validators (ETag/Last-Modified) and results
+// of every page between crawls, so that a re-crawl only has to download the
+// pages that have changed since the previous crawl.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
+)
+
+// the ways in which a page can differ from the previous crawl.
+const (
+	added     = "new"
+	changed   = "changed"
+	unchanged = "unchanged"
+)
+
+// Entry is what we remember about a single page.
+//
+// Hash is a hash of the page body, which lets us tell whether a page changed
+// even when the server doesn't support conditional requests.
+type Entry struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	Hash         string
+	Page         mapper.Page
+}
+
+// Changes summarises how the crawled pages differ from the previous crawl.
+type Changes struct {
+	New       []string
+	Changed   []string
+	Unchanged []string
+	Removed   []string
+}
+
+// Cache holds the entries from the previous crawl along with the entries for
+// the current crawl, and is safe for concurrent use.
+//
+// A nil *Cache is valid, and behaves as if there was no previous crawl.
+type Cache struct {
+	mutex    sync.Mutex
+	previous map[string]Entry
+	current  map[string]Entry
+	changes  map[string]string
+}
+
+// Load reads the cache written by the previous crawl (a missing file simply
+// means there was no previous crawl).
+func Load(path string) (*Cache, error) {
+	c := &Cache{
+		previous: map[string]Entry{},
+		current:  map[string]Entry{},
+		changes:  map[string]string{},
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &c.previous); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Save writes the entries for the current crawl, replacing the previous ones.
+func (c *Cache) Save(path string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b, err := json.Marshal(c.current)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Validators returns the validators from the previous crawl for the given URL.
+func (c *Cache) Validators(url string) requester.Validators {
+	if c == nil {
+		return requester.Validators{}
+	}
+
+	entry, ok := c.previous[url]
+	if !ok {
+		return requester.Validators{}
+	}
+
+	return requester.Validators{ETag: entry.ETag, LastModified: entry.LastModified}
+}
+
+// Previous returns the result of the given URL from the previous crawl, which
+// is what we reuse when the server tells us the page hasn't been modified.
+func (c *Cache) Previous(url string) (mapper.Page, bool) {
+	if c == nil {
+		return mapper.Page{}, false
+	}
+
+	entry, ok := c.previous[url]
+	return entry.Page, ok
+}
+
+// Store records the response (and result) for the given URL, and determines
+// whether the page is new, changed or unchanged since the previous crawl.
+//
+// Only successful responses (i.e. a 200 or a 304) are cached.
+func (c *Cache) Store(url string, page requester.Page, mappedPage mapper.Page) {
+	if c == nil || page.Err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	previous, seen := c.previous[url]
+
+	var entry Entry
+	switch page.Status {
+	case http.StatusNotModified:
+		// a 304 may include updated validators, otherwise we keep the old ones
+		entry = previous
+		if etag := page.Header.Get("ETag"); etag != "" {
+			entry.ETag = etag
+		}
+		if lastModified := page.Header.Get("Last-Modified"); lastModified != "" {
+			entry.LastModified = lastModified
+		}
+	case http.StatusOK:
+		sum := sha256.Sum256(page.Body)
+		entry = Entry{
+			ETag:         page.Header.Get("ETag"),
+			LastModified: page.Header.Get("Last-Modified"),
+			Hash:         hex.EncodeToString(sum[:]),
+		}
+	default:
+		return
+	}
+	entry.Page = mappedPage
+
+	c.current[url] = entry
+
+	switch {
+	case !seen:
+		c.changes[url] = added
+	case page.Status == http.StatusNotModified || entry.Hash == previous.Hash:
+		c.changes[url] = unchanged
+	default:
+		c.changes[url] = changed
+	}
+}
+
+// Keep carries the previous entry for the given URL over to the current crawl
+// as is, for a URL that's part of the current crawl but which wasn't requested
+// again (e.g. it was processed before the crawl was resumed).
+func (c *Cache) Keep(url string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, ok := c.previous[url]; ok {
+		if _, stored := c.current[url]; !stored {
+			c.current[url] = entry
+		}
+	}
+}
+
+// KeepUnseen carries the previous entry of every URL the current crawl didn't
+// store over to the current crawl, which is what we want when the crawl ended
+// early (e.g. it was interrupted or reached a limit) as the pages it didn't get
+// around to haven't necessarily been removed.
+func (c *Cache) KeepUnseen() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for url, entry := range c.previous {
+		if _, stored := c.current[url]; !stored {
+			c.current[url] = entry
+		}
+	}
+}
+
+// Changes returns how the pages in the current crawl differ from the previous
+// crawl. A page from the previous crawl that wasn't successfully requested in
+// the current crawl (and wasn't kept) is considered removed.
+func (c *Cache) Changes() Changes {
+	var changes Changes
+	if c == nil {
+		return changes
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for url, change := range c.changes {
+		switch change {
+		case added:
+			changes.New = append(changes.New, url)
+		case changed:
+			changes.Changed = append(changes.Changed, url)
+		case unchanged:
+			changes.Unchanged = append(changes.Unchanged, url)
+		}
+	}
+
+	for url := range c.previous {
+		if _, ok := c.current[url]; !ok {
+			changes.Removed = append(changes.Removed, url)
+		}
+	}
+
+	for _, urls := range [][]string{changes.New, changes.Changed, changes.Unchanged, changes.Removed} {
+		sort.Strings(urls)
+	}
+
+	return changes
+}
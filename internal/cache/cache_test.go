@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
+)
+
+func response(status int, etag, body string) requester.Page {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	return requester.Page{Status: status, Header: header, Body: []byte(body)}
+}
+
+func TestChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cache.json")
+
+	// the first crawl has nothing to compare against
+	first, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := first.Validators("http://www.example.com/a"); v != (requester.Validators{}) {
+		t.Errorf("expected: %+v\ngot: %+v", requester.Validators{}, v)
+	}
+
+	for _, url := range []string{"a", "b", "c", "d"} {
+		url = "http://www.example.com/" + url
+		first.Store(url, response(200, `"`+url+`"`, url), mapper.Page{URL: url, Anchors: mapper.Assets{url + "/child"}})
+	}
+
+	if err := first.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := requester.Validators{ETag: `"http://www.example.com/a"`}
+	if v := second.Validators("http://www.example.com/a"); v != expected {
+		t.Errorf("expected: %+v\ngot: %+v", expected, v)
+	}
+
+	previous, ok := second.Previous("http://www.example.com/a")
+	if !ok || len(previous.Anchors) != 1 || previous.Anchors[0] != "http://www.example.com/a/child" {
+		t.Errorf("expected: %+v\ngot: %+v", "http://www.example.com/a/child", previous.Anchors)
+	}
+
+	second.Store("http://www.example.com/a", response(304, "", ""), previous)
+	second.Store("http://www.example.com/b", response(200, "", "http://www.example.com/b"), mapper.Page{})
+	second.Store("http://www.example.com/c", response(200, "", "changed"), mapper.Page{})
+	second.Store("http://www.example.com/e", response(200, "", "new"), mapper.Page{})
+
+	changes := second.Changes()
+
+	for _, tc := range []struct {
+		name     string
+		actual   []string
+		expected []string
+	}{
+		{"new", changes.New, []string{"http://www.example.com/e"}},
+		{"changed", changes.Changed, []string{"http://www.example.com/c"}},
+		{"unchanged", changes.Unchanged, []string{"http://www.example.com/a", "http://www.example.com/b"}},
+		{"removed", changes.Removed, []string{"http://www.example.com/d"}},
+	} {
+		if len(tc.actual) != len(tc.expected) {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.name, tc.expected, tc.actual)
+			continue
+		}
+
+		for i, url := range tc.actual {
+			if url != tc.expected[i] {
+				t.Errorf("%s expected: %+v\ngot: %+v", tc.name, tc.expected[i], url)
+			}
+		}
+	}
+}
+
+func TestKeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cache.json")
+
+	first, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"a", "b", "c", "d"} {
+		url = "http://www.example.com/" + url
+		first.Store(url, response(200, `"`+url+`"`, url), mapper.Page{URL: url})
+	}
+
+	if err := first.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second crawl is interrupted after requesting a single page, along
+	// with a page it had already processed before being resumed.
+	second, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+	second.Store("http://www.example.com/a", requester.Page{Status: 304, Header: header}, mapper.Page{})
+	second.Keep("http://www.example.com/b")
+
+	if changes := second.Changes(); len(changes.Removed) != 2 {
+		t.Errorf("expected: %+v\ngot: %+v", 2, changes.Removed)
+	}
+
+	second.KeepUnseen()
+
+	if changes := second.Changes(); len(changes.Removed) != 0 {
+		t.Errorf("expected nothing to be removed\ngot: %+v", changes.Removed)
+	}
+
+	if err := second.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		url      string
+		expected requester.Validators
+	}{
+		{"http://www.example.com/a", requester.Validators{ETag: `"http://www.example.com/a"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT"}},
+		{"http://www.example.com/b", requester.Validators{ETag: `"http://www.example.com/b"`}},
+		{"http://www.example.com/d", requester.Validators{ETag: `"http://www.example.com/d"`}},
+	} {
+		if v := third.Validators(tc.url); v != tc.expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.url, tc.expected, v)
+		}
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+
+	if _, ok := c.Previous("http://www.example.com/"); ok {
+		t.Errorf("expected: %+v\ngot: %+v", false, ok)
+	}
+
+	c.Store("http://www.example.com/", response(200, "", ""), mapper.Page{})
+
+	if changes := c.Changes(); len(changes.New) != 0 {
+		t.Errorf("expected no changes\ngot: %+v", changes)
+	}
+}
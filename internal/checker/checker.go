@@ -0,0 +1,209 @@
+package checker
+
+// The checker package implements the broken link checker mode. Every URL that
+// was found during the crawl (including links to external hosts and static
+// assets that we'd otherwise never request) is checked, and any that are
+// broken are reported along with every page that references them.
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/normalizer"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/robots"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultWorkerPool = 20
+
+// Result represents a broken URL, which either responded with an error status
+// (i.e. 4xx or 5xx) or couldn't be requested at all.
+type Result struct {
+	URL     string
+	Status  int    `json:",omitempty"`
+	Error   string `json:",omitempty"`
+	Sources []string
+}
+
+// Check returns every broken URL referenced by the crawled pages.
+//
+// URLs that were crawled already have a known status, and so only the URLs
+// that weren't crawled are requested. A HEAD request is made first (as we
+// don't need the body) falling back to a GET request when that fails, as some
+// servers don't support HEAD requests (or respond to them incorrectly).
+//
+// URLs disallowed by robots.txt aren't requested, and so can't be reported.
+func Check(ctx context.Context, results []mapper.Page, robotsRules *robots.Robots, httpclient requester.HTTPClient, instr *instrumentator.Instr) []Result {
+	crawled := map[string]mapper.Page{}
+	for _, page := range results {
+		crawled[page.URL] = page
+
+		// a redirected page is reported under its final URL, but other pages will
+		// link to the URL that was originally requested.
+		if len(page.Redirects) > 0 {
+			crawled[normalizer.String(page.Redirects[0].URL)] = page
+		}
+	}
+
+	sources := references(results)
+
+	var mutex = &sync.Mutex{}
+	var wg sync.WaitGroup
+	var broken []Result
+	var unchecked []string
+
+	for url := range sources {
+		page, ok := crawled[url]
+		if !ok {
+			if robotsRules.Allowed(url) {
+				unchecked = append(unchecked, url)
+			}
+			continue
+		}
+
+		switch {
+		case page.Blocked:
+			continue
+		case page.Error != "" || page.Status >= 400:
+			broken = append(broken, Result{URL: url, Status: page.Status, Error: page.Error})
+		}
+	}
+
+	// dynamically determine the worker pool size, we'll either set a default or
+	// use a smaller value if the number of tasks is smaller than the default.
+	toProcess := len(unchecked)
+	workerPool := defaultWorkerPool
+	if toProcess < defaultWorkerPool {
+		workerPool = toProcess
+	}
+
+	startTime := time.Now()
+	tasks := make(chan string, workerPool)
+
+	// spin up our worker pool as goroutines awaiting tasks to be processed
+	for i := 0; i < workerPool; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for url := range tasks {
+				result, ok := check(ctx, url, httpclient, instr)
+				if !ok {
+					continue
+				}
+
+				// we use a mutex to ensure thread safety, not only for the correctness
+				// of the program but also because the Go language can trigger a panic!
+				mutex.Lock()
+				broken = append(broken, result)
+				mutex.Unlock()
+			}
+		}(i)
+	}
+
+dispatch:
+	for _, url := range unchecked {
+		select {
+		case tasks <- url:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+
+	close(tasks)
+
+	wg.Wait()
+	instr.Logger.Debug("time spent checking:", time.Since(startTime))
+
+	for i := range broken {
+		broken[i].Sources = sources[broken[i].URL]
+		sort.Strings(broken[i].Sources)
+	}
+
+	sort.Slice(broken, func(i, j int) bool {
+		return broken[i].URL < broken[j].URL
+	})
+
+	return broken
+}
+
+// check requests a single URL, and returns a Result if the URL is broken.
+func check(ctx context.Context, url string, httpclient requester.HTTPClient, instr *instrumentator.Instr) (Result, bool) {
+	page, err := requester.Head(ctx, url, httpclient)
+	if err != nil || page.Status >= 400 {
+		page, err = requester.Get(ctx, url, httpclient)
+	}
+
+	// a request that failed because the user interrupted the program doesn't
+	// tell us anything about the URL.
+	if ctx.Err() != nil {
+		return Result{}, false
+	}
+
+	if err == nil && page.Status < 400 {
+		return Result{}, false
+	}
+
+	instr.Logger.WithFields(logrus.Fields{"url": url, "status": page.Status}).Debug("LINK_BROKEN")
+
+	result := Result{URL: url, Status: page.Status}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, true
+}
+
+// references maps every URL found on the crawled pages to the pages it was
+// found on.
+func references(results []mapper.Page) map[string][]string {
+	sources := map[string][]string{}
+
+	for _, page := range results {
+		// note: a resource hint (e.g. a <link rel="preconnect"> to a font's origin)
+		// often refers to a bare origin that doesn't respond successfully, and so
+		// the links (and external URLs) that are only hints aren't checked.
+		hints := map[string]bool{}
+		for _, url := range page.Hints {
+			hints[url] = true
+		}
+
+		// note: the mapper only removes duplicate URLs within each class of asset
+		// (e.g. a linked image is both an anchor and an image), so we make sure a
+		// page is only appended once per URL.
+		seen := map[string]bool{}
+
+		// note: form actions aren't checked, as they typically only accept a POST
+		// request and so would be reported as broken.
+		for _, class := range []struct {
+			assets mapper.Assets
+			hinted bool
+		}{
+			{page.Anchors, false},
+			{page.Links, true},
+			{page.Scripts, false},
+			{page.Images, false},
+			{page.Media, false},
+			{page.Iframes, false},
+			{page.Objects, false},
+			{page.CSSAssets, false},
+			{page.External, true},
+		} {
+			for _, url := range class.assets {
+				if (class.hinted && hints[url]) || seen[url] {
+					continue
+				}
+
+				seen[url] = true
+				sources[url] = append(sources[url], page.URL)
+			}
+		}
+	}
+
+	return sources
+}
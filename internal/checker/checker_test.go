@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	results := []mapper.Page{
+		{
+			URL:      "http://www.example.com/",
+			Status:   200,
+			Anchors:  mapper.Assets{"http://www.example.com/gone", "http://www.example.com/about"},
+			Scripts:  mapper.Assets{server.URL + "/missing.js"},
+			Links:    mapper.Assets{"http://www.example.com/preload.css"},
+			External: mapper.Assets{server.URL + "/ok", server.URL + "/no-head", server.URL},
+			// a <link rel="preconnect"> to an origin (and a preload) aren't checked.
+			Hints: mapper.Assets{server.URL, "http://www.example.com/preload.css"},
+		},
+		{
+			URL:      "http://www.example.com/about",
+			Status:   200,
+			Anchors:  mapper.Assets{"http://www.example.com/gone"},
+			Images:   mapper.Assets{"http://www.example.com/gone"},
+			External: mapper.Assets{server.URL + "/missing"},
+		},
+		{
+			URL:    "http://www.example.com/gone",
+			Status: 404,
+		},
+	}
+
+	actual := Check(context.Background(), results, nil, http.DefaultClient, &instr)
+
+	expected := []Result{
+		{URL: server.URL + "/missing", Status: 404, Sources: []string{"http://www.example.com/about"}},
+		{URL: server.URL + "/missing.js", Status: 404, Sources: []string{"http://www.example.com/"}},
+		{URL: "http://www.example.com/gone", Status: 404, Sources: []string{"http://www.example.com/", "http://www.example.com/about"}},
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, actual)
+	}
+
+	for i, result := range actual {
+		if result.URL != expected[i].URL || result.Status != expected[i].Status {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], result)
+		}
+
+		if len(result.Sources) != len(expected[i].Sources) {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i].Sources, result.Sources)
+			continue
+		}
+
+		for j, source := range result.Sources {
+			if source != expected[i].Sources[j] {
+				t.Errorf("expected: %+v\ngot: %+v", expected[i].Sources[j], source)
+			}
+		}
+	}
+}
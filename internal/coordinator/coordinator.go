@@ -1,94 +1,553 @@
 package coordinator
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/integralist/go-web-crawler/internal/cache"
+	"github.com/integralist/go-web-crawler/internal/checker"
 	"github.com/integralist/go-web-crawler/internal/crawler"
 	"github.com/integralist/go-web-crawler/internal/formatter"
+	"github.com/integralist/go-web-crawler/internal/frontier"
 	"github.com/integralist/go-web-crawler/internal/instrumentator"
 	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/normalizer"
 	"github.com/integralist/go-web-crawler/internal/parser"
 	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/sitemap"
+	"github.com/integralist/go-web-crawler/internal/state"
+	"github.com/sirupsen/logrus"
 )
 
+const defaultWorkerPool = 20
+
+// workerPool is the number of workers draining the frontier queue.
+//
+// note: it's a variable so that the tests can use a single worker, as that
+// makes the order the pages are crawled in (and so the results) deterministic.
+var workerPool = defaultWorkerPool
+
 // ProcessedResults are the final results slice containing all crawled pages.
 type ProcessedResults []mapper.Page
 
+// Limits bound the size of a crawl (a zero value means no limit).
+//
+// note: a MaxDepth of zero only crawls the entry page (along with any seeds),
+// and so it's NoMaxDepth that means there's no depth limit.
+type Limits struct {
+	MaxDepth    int
+	MaxPages    int
+	MaxDuration time.Duration
+	MaxBytes    int64
+}
+
+// NoMaxDepth disables the depth limit.
+const NoMaxDepth = -1
+
+// Options are the optional parts of a crawl (a zero value for any of them
+// means that part of the crawl is disabled).
+type Options struct {
+	// Seeds are the URLs listed in the site's sitemaps.
+	Seeds []string
+
+	// Store persists the crawl's progress.
+	Store *state.Store
+
+	// Cache holds the results of the previous crawl.
+	Cache *cache.Cache
+
+	// Stylesheets scans the stylesheets of each page for the resources they
+	// reference.
+	Stylesheets *crawler.Stylesheets
+
+	// OnPage is called with each page as soon as it has been mapped.
+	OnPage func(mapper.Page)
+
+	// Discard only passes each page to OnPage, rather than also keeping it in
+	// the returned results, so that streaming a large crawl uses a flat amount of
+	// memory (it can't be used along with Seeds, as finding the orphan pages
+	// needs the results).
+	Discard bool
+}
+
+// crawl holds the state shared by every worker for the duration of a crawl,
+// which saves us from passing a long list of arguments between functions.
+type crawl struct {
+	ctx         context.Context
+	limits      Limits
+	queue       *frontier.Queue
+	trackedURLs crawler.Tracker
+	httpclient  requester.HTTPClient
+	instr       *instrumentator.Instr
+
+	// store persists the crawl's progress (a nil value means it isn't persisted).
+	store *state.Store
+
+	// cache holds the results of the previous crawl (a nil value means there's
+	// no previous crawl to compare against).
+	cache *cache.Cache
+
+	// stylesheets scans the stylesheets of each page for the resources they
+	// reference (a nil value means stylesheets aren't requested).
+	stylesheets *crawler.Stylesheets
+
+	// onPage is called with each page as soon as it has been mapped (a nil value
+	// means nobody is interested).
+	onPage func(mapper.Page)
+
+	// discard indicates the pages are only passed to onPage.
+	discard bool
+
+	// pages and bytes are updated atomically as they're shared by the workers.
+	pages int64
+	bytes int64
+
+	depthLimited int32
+	stopMutex    sync.Mutex
+	stopReason   string
+}
+
 // Start begins crawling the given website starting with the entry page.
-func Start(protocol, hostname string, httpclient requester.HTTPClient, instr *instrumentator.Instr) ProcessedResults {
-	// request entrypoint web page
-	pageURL := fmt.Sprintf("%s://%s", protocol, hostname)
-	page, err := requester.Get(pageURL, httpclient)
-	if err != nil {
-		instr.Logger.Fatal(err)
-	}
+//
+// Any Seeds (i.e. the URLs listed in the site's sitemaps) are queued as
+// additional entry points, meaning pages that aren't linked to from anywhere
+// are still crawled (and can be reported as orphans).
+//
+// When a Cache is given, each page is requested conditionally using the
+// validators from the previous crawl, and the previous result is reused for
+// any page that hasn't been modified.
+//
+// When Stylesheets is given, the stylesheets linked to by each page are
+// requested (once per crawl) and the resources they reference are added to the
+// page's CSSAssets.
+//
+// When OnPage is given, it's called with each page the moment it has been
+// mapped (calls are never concurrent, and are in the same order as the results)
+// which allows the results to be streamed rather than waiting for the crawl to
+// finish.
+//
+// When a Store is given, the crawl's progress is persisted to it as we go and
+// if the store contains a previous (interrupted) crawl then we pick up where it
+// left off rather than starting again from the entry page.
+//
+// Cancelling the context stops any new URLs from being dispatched, cancels any
+// in-flight requests, and returns the results gathered up to that point.
+func Start(ctx context.Context, protocol, hostname string, order frontier.Order, limits Limits, opts Options, httpclient requester.HTTPClient, instr *instrumentator.Instr) (ProcessedResults, formatter.Summary) {
+	store := opts.Store
+	previous := opts.Cache
 
-	if page.Status != 200 {
-		instr.Logger.Fatal("Non 200 for entry page")
-	}
+	pageURL := normalizer.String(fmt.Sprintf("%s://%s", protocol, hostname))
 
 	// to prevent doubling up the processing of urls that have already been
 	// handled, we'll use a a hash table for O(1) constant time lookups.
-	trackedURLs := new(sync.Map)
-	trackedURLs.Store(pageURL, true)
+	var trackedURLs crawler.Tracker = new(sync.Map)
+	if store != nil {
+		trackedURLs = store
+	}
 
-	// parse the requested page
-	tokenizedPage := parser.Parse(page, instr)
+	c := &crawl{
+		ctx:         ctx,
+		limits:      limits,
+		queue:       frontier.New(order),
+		trackedURLs: trackedURLs,
+		httpclient:  httpclient,
+		instr:       instr,
+		store:       store,
+		cache:       previous,
+		stylesheets: opts.Stylesheets,
+		onPage:      opts.OnPage,
+		discard:     opts.Discard,
+	}
 
-	// map the tokenized page, and its assets
-	mappedPage := mapper.Map(tokenizedPage)
+	// the duration limit is enforced by a timer which stops the queue, meaning
+	// no more URLs are handed out but in-flight pages can still finish.
+	var timer *time.Timer
+	if limits.MaxDuration > 0 {
+		timer = time.AfterFunc(limits.MaxDuration, func() {
+			c.stop(fmt.Sprintf("max-duration limit reached (%s)", limits.MaxDuration))
+		})
+	}
 
 	// results stores the final structure of crawled pages and their assets.
-	var results []mapper.Page
+	var results ProcessedResults
 
-	// now we have the initial page analysis, we'll process the anchors.
-	//
-	// note: as the `process` function is recursive, we need to pass a slice of
-	// mapper.Page type.
-	//
-	// would be good to avoid the code smell of wrapping our single page instance
-	// within a slice by maybe replacing the []T with variadic arguments, but
-	// that is likely to result in other trade-offs.
-	entryPage := ProcessedResults{mappedPage}
-	results = process(entryPage, results, trackedURLs, httpclient, instr)
+	if store != nil && store.Resumed() {
+		// the previous crawl has already processed the entry page, and so we only
+		// need to queue the URLs it didn't get around to processing.
+		//
+		// note: these were recorded when they were first queued, which is why we
+		// don't use c.push here.
+		for _, page := range store.Results() {
+			c.emit(page)
+
+			// the cache is keyed by the URL that was requested, which for a redirected
+			// page is the first hop rather than the URL it was served from.
+			previous.Keep(page.URL)
+			if len(page.Redirects) > 0 {
+				previous.Keep(normalizer.String(page.Redirects[0].URL))
+			}
+
+			c.keep(&results, page)
+		}
+		pages, bytes := store.Processed()
+		for _, page := range results {
+			// a blocked URL was never requested, so it doesn't count as a page.
+			if page.Blocked {
+				pages--
+			}
+		}
+		c.pages = int64(pages)
+		c.bytes = bytes
+
+		for _, item := range store.Pending() {
+			c.queue.Push(item)
+		}
+	} else {
+		// request entrypoint web page
+		page := crawler.Fetch(ctx, pageURL, previous.Validators(pageURL), httpclient, instr)
+
+		// note: the crawl can be interrupted before the entry page has even been
+		// requested, in which case there's nothing to crawl but the (empty)
+		// results are still returned so that the output is written as usual.
+		if page.Err != nil && ctx.Err() != nil {
+			if timer != nil {
+				timer.Stop()
+			}
+			return results, formatter.Summary{StopReason: "interrupted"}
+		}
+
+		if page.Err != nil {
+			instr.Logger.Fatal(page.Err)
+		}
+
+		if page.Status != 200 && page.Status != http.StatusNotModified {
+			instr.Logger.Fatal("Non 200 for entry page")
+		}
+
+		trackedURLs.Store(pageURL, true)
+		trackedURLs.Store(finalURL(page), true)
+		c.pages = 1
+		c.bytes = int64(len(page.Body))
+
+		// parse and map the requested page, and its assets
+		mappedPage := c.mapPage(pageURL, page)
+
+		c.emit(mappedPage)
+		c.keep(&results, mappedPage)
+
+		// rather than recursively crawling each page's anchors in batches, every
+		// discovered URL goes into a single frontier queue which is drained by a
+		// fixed pool of workers (meaning we get site-wide parallelism).
+		c.enqueue(mappedPage)
+		c.done(pageURL, c.bytes, &mappedPage)
+	}
+
+	// seeds are validated (and normalized) in the same way as anchors, and are
+	// queued at the same depth as the entry page.
+	var listed []string
+	for _, seed := range opts.Seeds {
+		url, ok := parser.ValidURL(seed)
+		if !ok {
+			continue
+		}
+
+		listed = append(listed, url)
+		if _, loaded := c.trackedURLs.LoadOrStore(url, true); !loaded {
+			c.push(frontier.Item{URL: url})
+		}
+	}
+
+	if limits.MaxBytes > 0 && c.bytes >= limits.MaxBytes {
+		c.stop(fmt.Sprintf("max-bytes limit reached (%d)", limits.MaxBytes))
+	}
+
+	var mutex = &sync.Mutex{}
+	var wg sync.WaitGroup
+
+	startTime := time.Now()
+
+	// once the context is cancelled (e.g. the user pressed Ctrl-C) we stop the
+	// queue so the workers finish up with whatever they're currently processing.
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.stop("interrupted")
+		case <-finished:
+		}
+	}()
+
+	for i := 0; i < workerPool; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for {
+				item, ok := c.queue.Pop()
+				if !ok {
+					return
+				}
+
+				if crawledPage, ok := c.process(item); ok {
+					// we use a mutex to ensure thread safety, not only for the correctness
+					// of the program but also because the Go language can trigger a panic!
+					mutex.Lock()
+					c.emit(crawledPage)
+					c.keep(&results, crawledPage)
+					mutex.Unlock()
+				}
+
+				c.queue.Done()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(finished)
+	instr.Logger.Debug("time spent crawling:", time.Since(startTime))
 
-	return results
+	if timer != nil {
+		timer.Stop()
+	}
+
+	// the depth limit doesn't stop the crawl early, but if it prevented any URL
+	// from being crawled then it's the reason the crawl ended where it did.
+	if atomic.LoadInt32(&c.depthLimited) > 0 {
+		c.stop(fmt.Sprintf("max-depth limit reached (%d)", limits.MaxDepth))
+	}
+
+	c.stopMutex.Lock()
+	defer c.stopMutex.Unlock()
+
+	summary := formatter.Summary{
+		StopReason: c.stopReason,
+		Excluded:   parser.Excluded(),
+		Seeded:     len(listed),
+	}
+
+	if len(listed) > 0 && !c.discard {
+		summary.Orphans, summary.Unlisted = sitemap.Orphans(results, listed, pageURL)
+	}
+
+	return results, summary
 }
 
 // Results displays the final output for the program.
-func Results(results []mapper.Page, json, dot bool, startTime time.Time) {
-	if json {
-		formatter.Pretty(results)
+//
+// in check mode the JSON output also includes the list of broken URLs, as
+// that's what a CI pipeline would be interested in.
+//
+// note: the broken URLs are always a list (even when there aren't any) so that
+// a pipeline can rely on its type.
+func Results(results []mapper.Page, summary formatter.Summary, json, dot bool, dotConfig formatter.DotConfig, graphml, gexf bool, startTime time.Time) {
+	if json && summary.Checked {
+		broken := summary.Broken
+		if broken == nil {
+			broken = []checker.Result{}
+		}
+		fmt.Println(formatter.Pretty(formatter.CheckedResults{Pages: results, Broken: broken}))
+	} else if json {
+		fmt.Println(formatter.Pretty(results))
 	} else if dot {
-		formatter.Dot(results)
+		fmt.Println(formatter.Dot(results, dotConfig))
+	} else if graphml {
+		fmt.Println(formatter.GraphML(results))
+	} else if gexf {
+		fmt.Println(formatter.GEXF(results))
+	} else {
+		formatter.Standard(results, summary, startTime)
+	}
+}
+
+// stop ends the crawl early, and records the reason (only the first reason is
+// kept as that is the limit that actually ended the crawl).
+func (c *crawl) stop(reason string) {
+	c.stopMutex.Lock()
+	defer c.stopMutex.Unlock()
+
+	if c.stopReason == "" {
+		c.stopReason = reason
+		c.queue.Stop()
+	}
+}
+
+// process fetches, parses and maps a single queued URL, and then queues any
+// anchors it contains that haven't been seen before.
+//
+// the returned bool indicates whether the page should be part of the results.
+func (c *crawl) process(item frontier.Item) (mapper.Page, bool) {
+	// note: a URL that robots.txt disallows is never requested, and so it doesn't
+	// count towards the page limit.
+	if c.limits.MaxPages > 0 && crawler.Allowed(item.URL) && atomic.AddInt64(&c.pages, 1) > int64(c.limits.MaxPages) {
+		c.stop(fmt.Sprintf("max-pages limit reached (%d)", c.limits.MaxPages))
+		return mapper.Page{}, false
+	}
+
+	page := crawler.Fetch(c.ctx, item.URL, c.cache.Validators(item.URL), c.httpclient, c.instr)
+
+	// a request that failed because the crawl was interrupted isn't a genuine
+	// failure, so we leave it out of the results.
+	if page.Err != nil && c.ctx.Err() != nil {
+		return mapper.Page{}, false
+	}
+
+	size := int64(len(page.Body))
+	bytes := atomic.AddInt64(&c.bytes, size)
+	if c.limits.MaxBytes > 0 && bytes >= c.limits.MaxBytes {
+		c.stop(fmt.Sprintf("max-bytes limit reached (%d)", c.limits.MaxBytes))
+	}
+
+	// a redirected page is tracked under the URL it was actually served from,
+	// and if that URL has already been tracked (e.g. another page links to it
+	// directly) then it has been (or will be) crawled and we don't crawl it again.
+	//
+	// note: we still keep a result for the URL we requested, otherwise its
+	// redirects (e.g. a chain of redirects) would never be reported.
+	url := finalURL(page)
+	if url != item.URL {
+		if _, loaded := c.trackedURLs.LoadOrStore(url, true); loaded {
+			c.instr.Logger.Debug("redirect to tracked page:", url)
+
+			mappedPage := mapper.Page{
+				URL:         item.URL,
+				FinalURL:    url,
+				Status:      page.Status,
+				ContentType: page.ContentType,
+				Depth:       item.Depth,
+				Redirects:   page.Redirects,
+			}
+			c.cache.Keep(item.URL)
+			c.done(item.URL, size, &mappedPage)
+
+			return mappedPage, true
+		}
+	}
+
+	mappedPage := c.mapPage(item.URL, page)
+	mappedPage.Depth = item.Depth
+
+	// pages that failed, or responded with a non 200 status, are still included
+	// in the results (so they can be reported) but have no anchors to queue.
+	if mappedPage.Error == "" && mappedPage.Status == 200 {
+		c.enqueue(mappedPage)
+	}
+
+	c.done(item.URL, size, &mappedPage)
+
+	return mappedPage, true
+}
+
+// mapPage parses and maps a page, unless the server told us the page hasn't
+// been modified since the previous crawl (in which case we reuse the result
+// from the previous crawl), and then records the result in the cache.
+//
+// note: a page that hasn't been modified keeps the CSSAssets found by the
+// previous crawl, and so its stylesheets aren't requested again either.
+func (c *crawl) mapPage(url string, page requester.Page) mapper.Page {
+	mappedPage, ok := c.cache.Previous(url)
+	if page.Err == nil && page.Status == http.StatusNotModified && ok {
+		// a 304 can include an updated Last-Modified (just like the validators).
+		if lastModified := parser.LastModified(page.Header); lastModified != "" {
+			mappedPage.LastModified = lastModified
+		}
 	} else {
-		formatter.Standard(results, startTime)
+		parsedPage := parser.Parse(page, c.instr)
+		mappedPage = mapper.Map(parsedPage)
+
+		if c.stylesheets != nil && len(parsedPage.Stylesheets) > 0 {
+			assets, external := c.stylesheets.Scan(c.ctx, parsedPage.Stylesheets)
+			mappedPage = mapper.AppendCSSAssets(mappedPage, assets, external)
+		}
+	}
+	mappedPage.URL = finalURL(page)
+
+	c.cache.Store(url, page, mappedPage)
+
+	return mappedPage
+}
+
+// keep appends a mapped page to the results (unless they're being discarded).
+func (c *crawl) keep(results *ProcessedResults, page mapper.Page) {
+	if !c.discard {
+		*results = append(*results, page)
+	}
+}
+
+// emit passes a mapped page to the onPage callback (if there is one).
+func (c *crawl) emit(page mapper.Page) {
+	if c.onPage != nil {
+		c.onPage(page)
 	}
 }
 
-// process recursively calls itself and processes the next set of mapped pages.
-func process(
-	mappedPages ProcessedResults,
-	results []mapper.Page,
-	trackedURLs crawler.Tracker,
-	httpclient requester.HTTPClient,
-	instr *instrumentator.Instr) ProcessedResults {
+// push queues an item, recording it in the store so that it will be requeued
+// if the crawl is interrupted and later resumed.
+func (c *crawl) push(item frontier.Item) {
+	c.queue.Push(item)
 
-	for _, page := range mappedPages {
-		crawledPages := crawler.Crawl(page, trackedURLs, httpclient, instr)
-		tokenizedNestedPages := parser.ParseCollection(crawledPages, instr)
-		mappedNestedPages := mapper.MapCollection(tokenizedNestedPages, instr)
+	if c.store != nil {
+		if err := c.store.Queued(item); err != nil {
+			c.instr.Logger.WithFields(logrus.Fields{"url": item.URL}).WithError(err).Warn("STATE_WRITE_FAILED")
+		}
+	}
+}
+
+// done records a processed URL (and its result) in the store.
+//
+// note: the anchors of a page must be queued before the page is recorded as
+// done, otherwise an interruption in between would lose them.
+func (c *crawl) done(url string, bytes int64, page *mapper.Page) {
+	if c.store == nil {
+		return
+	}
+
+	if err := c.store.Done(url, bytes, page); err != nil {
+		c.instr.Logger.WithFields(logrus.Fields{"url": url}).WithError(err).Warn("STATE_WRITE_FAILED")
+	}
+}
 
-		for _, mnp := range mappedNestedPages {
-			results = append(results, mnp)
+// finalURL returns the normalized URL a page was served from (i.e. after any
+// redirects were followed), which is the key the page is tracked under.
+func finalURL(page requester.Page) string {
+	if page.Err != nil || page.FinalURL == "" {
+		return normalizer.String(page.URL)
+	}
+	return normalizer.String(page.FinalURL)
+}
+
+// enqueue pushes the anchors of a mapped page that haven't already been seen
+// (and that we've been allowed to follow) onto the frontier queue.
+func (c *crawl) enqueue(mappedPage mapper.Page) {
+	var queued int
+
+	anchors := crawler.Follow(mappedPage)
+
+	depth := mappedPage.Depth + 1
+	if c.limits.MaxDepth != NoMaxDepth && depth > c.limits.MaxDepth {
+		if len(anchors) > 0 {
+			atomic.AddInt32(&c.depthLimited, 1)
 		}
+		crawler.Progress(mappedPage, queued)
+		return
+	}
 
-		// reassign the results so we can return them up the stack back to the
-		// original caller for final display
-		results = process(mappedNestedPages, results, trackedURLs, httpclient, instr)
+	for _, url := range anchors {
+		// the parser already normalizes anchors, but every tracked key must be in
+		// canonical form and normalizing is idempotent so we don't rely on it.
+		url = normalizer.String(url)
+
+		// note: LoadOrStore is atomic, so two workers finding the same anchor at
+		// the same time can't both end up queueing it.
+		if _, loaded := c.trackedURLs.LoadOrStore(url, true); !loaded {
+			c.push(frontier.Item{URL: url, Depth: depth})
+			queued++
+		}
 	}
 
-	return results
+	crawler.Progress(mappedPage, queued)
 }
@@ -0,0 +1,422 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/integralist/go-web-crawler/internal/checker"
+	"github.com/integralist/go-web-crawler/internal/crawler"
+	"github.com/integralist/go-web-crawler/internal/formatter"
+	"github.com/integralist/go-web-crawler/internal/frontier"
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/parser"
+	"github.com/integralist/go-web-crawler/internal/robots"
+	"github.com/sirupsen/logrus"
+)
+
+// site serves the given pages (keyed by path) as HTML documents, and redirects
+// each of the given paths to its target.
+func site(pages map[string]string, redirects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(body))
+	}))
+}
+
+// crawlSite crawls the given server from its root page.
+func crawlSite(ctx context.Context, server *httptest.Server, order frontier.Order, limits Limits) (ProcessedResults, formatter.Summary) {
+	u, _ := url.Parse(server.URL)
+
+	parser.Init("http", u.Host, "")
+	crawler.Init(true, false, nil, crawler.RobotsMetaReport)
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	return Start(ctx, "http", u.Host, order, limits, Options{}, server.Client(), &instr)
+}
+
+// byURL indexes the results by their URL.
+func byURL(results ProcessedResults) map[string]mapper.Page {
+	pages := map[string]mapper.Page{}
+	for _, page := range results {
+		pages[page.URL] = page
+	}
+	return pages
+}
+
+func TestStartRedirectToTrackedPage(t *testing.T) {
+	server := site(map[string]string{
+		"/":  `<a href="/a">a</a> <a href="/old">old</a>`,
+		"/a": `a`,
+	}, map[string]string{
+		"/old": "/hop",
+		"/hop": "/a",
+	})
+	defer server.Close()
+
+	results, _ := crawlSite(context.Background(), server, frontier.BFS, Limits{MaxDepth: NoMaxDepth})
+
+	if len(results) != 3 {
+		t.Fatalf("expected: %+v\ngot: %+v", 3, results)
+	}
+
+	pages := byURL(results)
+
+	// the page the redirects arrived at is only crawled once...
+	if page := pages[server.URL+"/a"]; page.FinalURL != "" || len(page.Redirects) != 0 {
+		t.Errorf("expected: %+v\ngot: %+v", "a page without redirects", page)
+	}
+
+	// ...but the chain of redirects that led to it is still reported.
+	old := pages[server.URL+"/old"]
+	if old.FinalURL != server.URL+"/a" {
+		t.Errorf("expected: %+v\ngot: %+v", server.URL+"/a", old.FinalURL)
+	}
+
+	if len(old.Redirects) != 2 || old.Redirects[0].URL != server.URL+"/old" || old.Redirects[1].URL != server.URL+"/hop" {
+		t.Errorf("expected: %+v\ngot: %+v", "/old -> /hop", old.Redirects)
+	}
+}
+
+// paths returns the path of each result, in the order they were crawled.
+func paths(server *httptest.Server, results ProcessedResults) []string {
+	var paths []string
+	for _, page := range results {
+		path := strings.TrimPrefix(page.URL, server.URL)
+		if path == "" {
+			path = "/"
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestStartTerminates(t *testing.T) {
+	// every page links back to the others, so the crawl only ends because each
+	// URL is tracked once it has been queued.
+	server := site(map[string]string{
+		"/":  `<a href="/a">a</a> <a href="/b">b</a>`,
+		"/a": `<a href="/">home</a> <a href="/b">b</a> <a href="/a">a</a>`,
+		"/b": `<a href="/">home</a> <a href="/a">a</a> <a href="/missing">missing</a>`,
+	}, nil)
+	defer server.Close()
+
+	done := make(chan ProcessedResults)
+	go func() {
+		results, _ := crawlSite(context.Background(), server, frontier.BFS, Limits{MaxDepth: NoMaxDepth})
+		done <- results
+	}()
+
+	select {
+	case results := <-done:
+		expected := []string{"/", "/a", "/b", "/missing"}
+		actual := paths(server, results)
+		sort.Strings(actual)
+		if strings.Join(actual, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the crawl to finish")
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	defer func() { workerPool = defaultWorkerPool }()
+	workerPool = 1
+
+	server := site(map[string]string{
+		"/":   `<a href="/a">a</a> <a href="/b">b</a>`,
+		"/a":  `<a href="/a1">a1</a>`,
+		"/b":  `<a href="/b1">b1</a>`,
+		"/a1": `a1`,
+		"/b1": `b1`,
+	}, nil)
+	defer server.Close()
+
+	depths := map[string]int{"/": 0, "/a": 1, "/b": 1, "/a1": 2, "/b1": 2}
+
+	testCases := []struct {
+		order    frontier.Order
+		expected []string
+	}{
+		{frontier.BFS, []string{"/", "/a", "/b", "/a1", "/b1"}},
+		{frontier.DFS, []string{"/", "/b", "/b1", "/a", "/a1"}},
+	}
+
+	for _, tc := range testCases {
+		results, _ := crawlSite(context.Background(), server, tc.order, Limits{MaxDepth: NoMaxDepth})
+
+		actual := paths(server, results)
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.order, tc.expected, actual)
+		}
+
+		for i, page := range results {
+			if page.Depth != depths[actual[i]] {
+				t.Errorf("%s %s\nexpected: %+v\ngot: %+v", tc.order, actual[i], depths[actual[i]], page.Depth)
+			}
+		}
+	}
+}
+
+func TestStartLimits(t *testing.T) {
+	defer func() { workerPool = defaultWorkerPool }()
+	workerPool = 1
+
+	server := site(map[string]string{
+		"/":   `<a href="/a">a</a> <a href="/b">b</a> <a href="/c">c</a>`,
+		"/a":  `<a href="/a1">a1</a>`,
+		"/b":  `b`,
+		"/c":  `c`,
+		"/a1": `a1`,
+	}, nil)
+	defer server.Close()
+
+	testCases := []struct {
+		name       string
+		limits     Limits
+		expected   []string
+		stopReason string
+	}{
+		{"none", Limits{MaxDepth: NoMaxDepth}, []string{"/", "/a", "/b", "/c", "/a1"}, ""},
+		{"entry page only", Limits{MaxDepth: 0}, []string{"/"}, "max-depth limit reached (0)"},
+		{"depth", Limits{MaxDepth: 1}, []string{"/", "/a", "/b", "/c"}, "max-depth limit reached (1)"},
+		{"pages", Limits{MaxDepth: NoMaxDepth, MaxPages: 3}, []string{"/", "/a", "/b"}, "max-pages limit reached (3)"},
+		{"bytes", Limits{MaxDepth: NoMaxDepth, MaxBytes: 1}, []string{"/"}, "max-bytes limit reached (1)"},
+	}
+
+	for _, tc := range testCases {
+		results, summary := crawlSite(context.Background(), server, frontier.BFS, tc.limits)
+
+		actual := paths(server, results)
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.name, tc.expected, actual)
+		}
+
+		if summary.StopReason != tc.stopReason {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.name, tc.stopReason, summary.StopReason)
+		}
+	}
+}
+
+func TestStartBlockedURLsDontCountAsPages(t *testing.T) {
+	defer func() { workerPool = defaultWorkerPool }()
+	workerPool = 1
+
+	server := site(map[string]string{
+		"/":           `<a href="/private">private</a> <a href="/a">a</a> <a href="/b">b</a>`,
+		"/a":          `a`,
+		"/b":          `b`,
+		"/robots.txt": "User-agent: *\nDisallow: /private",
+	}, nil)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	parser.Init("http", u.Host, "")
+	robotsRules := robots.Fetch(context.Background(), "http", parser.ValidHosts, server.Client(), &instr)
+	crawler.Init(true, false, robotsRules, crawler.RobotsMetaReport)
+	defer crawler.Init(true, false, nil, crawler.RobotsMetaReport)
+
+	results, _ := Start(context.Background(), "http", u.Host, frontier.BFS, Limits{MaxDepth: NoMaxDepth, MaxPages: 3}, Options{}, server.Client(), &instr)
+
+	expected := []string{"/", "/private", "/a", "/b"}
+	actual := paths(server, results)
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+	}
+
+	if !results[1].Blocked {
+		t.Errorf("expected: %+v\ngot: %+v", "/private to be blocked", results[1])
+	}
+}
+
+func TestStartCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the slow page cancels the crawl (as if the user pressed Ctrl-C) and then
+	// never responds, meaning the crawl only ends because it was cancelled.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/slow">slow</a>`))
+		case "/slow":
+			cancel()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		results, summary := crawlSite(ctx, server, frontier.BFS, Limits{MaxDepth: NoMaxDepth})
+
+		// the interrupted request isn't a genuine failure, so it's left out.
+		expected := []string{"/"}
+		if actual := paths(server, results); strings.Join(actual, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+		}
+
+		if summary.StopReason != "interrupted" {
+			t.Errorf("expected: %+v\ngot: %+v", "interrupted", summary.StopReason)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the crawl to stop once cancelled")
+	}
+}
+
+func TestStartCancelledEntryPage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the entry page cancels the crawl (as if the user pressed Ctrl-C) and then
+	// never responds.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		results, summary := crawlSite(ctx, server, frontier.BFS, Limits{MaxDepth: NoMaxDepth})
+
+		if len(results) != 0 {
+			t.Errorf("expected no results\ngot: %+v", results)
+		}
+
+		if summary.StopReason != "interrupted" {
+			t.Errorf("expected: %+v\ngot: %+v", "interrupted", summary.StopReason)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the crawl to stop once cancelled")
+	}
+}
+
+func TestStartDiscard(t *testing.T) {
+	server := site(map[string]string{
+		"/":  `<a href="/a">a</a> <a href="/b">b</a>`,
+		"/a": `a`,
+		"/b": `b`,
+	}, nil)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	parser.Init("http", u.Host, "")
+	crawler.Init(true, false, nil, crawler.RobotsMetaReport)
+
+	var streamed []string
+	results, _ := Start(context.Background(), "http", u.Host, frontier.BFS, Limits{MaxDepth: NoMaxDepth}, Options{
+		OnPage:  func(page mapper.Page) { streamed = append(streamed, page.URL) },
+		Discard: true,
+	}, server.Client(), &instr)
+
+	if len(results) != 0 {
+		t.Errorf("expected no results\ngot: %+v", results)
+	}
+
+	if len(streamed) != 3 {
+		t.Errorf("expected: %+v\ngot: %+v", 3, streamed)
+	}
+}
+
+// stdout returns whatever the given function prints to stdout.
+func stdout(t *testing.T, fn func()) []byte {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = original }()
+
+	fn()
+	w.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestResultsCheckJSON(t *testing.T) {
+	results := []mapper.Page{{URL: "http://www.example.com/", Status: 200}}
+
+	testCases := []struct {
+		name   string
+		broken []checker.Result
+	}{
+		{"broken", []checker.Result{{URL: "http://www.example.com/missing", Status: 404, Sources: []string{"http://www.example.com/"}}}},
+		{"none broken", nil},
+	}
+
+	for _, tc := range testCases {
+		summary := formatter.Summary{Checked: true, Broken: tc.broken}
+
+		output := stdout(t, func() {
+			Results(results, summary, true, false, formatter.DotConfig{}, false, false, time.Now())
+		})
+
+		var actual struct {
+			Pages  []mapper.Page
+			Broken *[]checker.Result
+		}
+		if err := json.Unmarshal(output, &actual); err != nil {
+			t.Fatalf("%s\nexpected: %+v\ngot: %s", tc.name, "a JSON object", output)
+		}
+
+		// the pages are still output, alongside the broken URLs.
+		if len(actual.Pages) != 1 || actual.Pages[0].URL != results[0].URL {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.name, results, actual.Pages)
+		}
+
+		if actual.Broken == nil || len(*actual.Broken) != len(tc.broken) {
+			t.Errorf("%s\nexpected: %+v\ngot: %s", tc.name, tc.broken, output)
+		}
+	}
+}
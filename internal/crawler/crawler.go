@@ -1,137 +1,213 @@
 package crawler
 
 import (
+	"context"
 	"fmt"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"path"
 	"strconv"
-	"sync"
-	"time"
 
 	"github.com/integralist/go-web-crawler/internal/formatter"
 	"github.com/integralist/go-web-crawler/internal/instrumentator"
 	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/parser"
 	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/robots"
 )
 
 // Tracker is a simplified version of sync.Map which will aid with testing.
 type Tracker interface {
 	Load(key interface{}) (value interface{}, ok bool)
+	LoadOrStore(key, value interface{}) (actual interface{}, loaded bool)
 	Store(key, value interface{})
 }
 
-const defaultWorkerPool = 20
-
 // dot indicates whether we should be outputting any print information
 var dot bool
 
 // json indicates whether we should be outputting any print information.
 var json bool
 
+// robotsRules are the robots.txt rules for each valid host (a nil value means
+// robots.txt is being ignored).
+var robotsRules *robots.Robots
+
+// RobotsMeta determines what we do about the nofollow directives of a page
+// (i.e. a rel="nofollow" anchor, or a robots <meta> element or X-Robots-Tag
+// header that includes nofollow).
+type RobotsMeta string
+
+const (
+	// RobotsMetaObey doesn't crawl the anchors a page asked us not to follow.
+	RobotsMetaObey RobotsMeta = "obey"
+
+	// RobotsMetaReport crawls every anchor, and only reports the directives.
+	RobotsMetaReport RobotsMeta = "report"
+)
+
+// ParseRobotsMeta validates a user provided robots meta behaviour.
+func ParseRobotsMeta(s string) (RobotsMeta, error) {
+	switch m := RobotsMeta(s); m {
+	case RobotsMetaObey, RobotsMetaReport:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown robots meta behaviour %q (expected %q or %q)", s, RobotsMetaObey, RobotsMetaReport)
+}
+
+// robotsMeta is set via Init.
+var robotsMeta = RobotsMetaReport
+
 // Init configures the package from an outside mediator
-func Init(j, d bool) {
+func Init(j, d bool, r *robots.Robots, m RobotsMeta) {
 	// it's ok to have json/dot as package level variables as they don't have a
 	// direct effect on the running of the program (other than information output)
 	json = j
 	dot = d
+	robotsRules = r
+	robotsMeta = m
 }
 
-// Crawl concurrently requests URLs extracted from a slice of mapper.Page
-func Crawl(mappedPage mapper.Page, trackedURLs Tracker, httpclient requester.HTTPClient, instr *instrumentator.Instr) []requester.Page {
-	toProcess := len(mappedPage.Anchors)
+// Follow returns the anchors of a page that should be crawled, which (when
+// obeying the robots directives) leaves out any the page asked us not to follow.
+func Follow(mappedPage mapper.Page) mapper.Assets {
+	if robotsMeta != RobotsMetaObey {
+		return mappedPage.Anchors
+	}
 
-	// avoid printing to stdout if user has requested json/dot formatted output
-	if !json && !dot {
-		fmt.Println("-------------------------")
-		fmt.Println(mappedPage.URL)
-		fmt.Printf("Contains %s URLs to crawl\n", formatter.Red(toProcess))
+	if mappedPage.NoFollow {
+		return nil
 	}
 
-	// if the page has no anchors associated within it, then we'll skip
-	// processing the current page
-	if toProcess < 1 {
-		if !json && !dot {
-			fmt.Printf("Crawled %s URLs %s\n\n", formatter.Green("0"), formatter.Green("(no pages requested)"))
+	noFollow := map[string]bool{}
+	for _, url := range mappedPage.NoFollowURLs {
+		noFollow[url] = true
+	}
+
+	var anchors mapper.Assets
+	for _, url := range mappedPage.Anchors {
+		if !noFollow[url] {
+			anchors = append(anchors, url)
 		}
-		return []requester.Page{}
 	}
+	return anchors
+}
+
+// Allowed reports whether robots.txt allows us to request the given URL.
+func Allowed(url string) bool {
+	return robotsRules.Allowed(url)
+}
+
+// Fetch requests a single URL, unless robots.txt disallows it.
+//
+// The validators from a previous crawl (if any) make the request conditional,
+// in which case an unchanged page results in a 304 status.
+//
+// Only the body of a HTML document is downloaded, as that's all we can crawl.
+// A URL whose extension tells us it's something else (e.g. an image or a PDF)
+// is requested with a HEAD request, and for any other URL the response's
+// Content-Type decides whether the body is downloaded. Either way we still
+// find out the resource's media type and size, so it can be reported.
+//
+// Rather than quietly dropping a URL that couldn't be requested, the failure
+// is recorded on the returned page so it can be reported alongside the rest of
+// the results.
+func Fetch(ctx context.Context, url string, v requester.Validators, httpclient requester.HTTPClient, instr *instrumentator.Instr) requester.Page {
+	return fetch(ctx, url, instr, func() (requester.Page, error) {
+		if !parser.NonHTML(url) {
+			return requester.GetHTML(ctx, url, v, httpclient)
+		}
 
-	var counter int
-	var mutex = &sync.Mutex{}
-	var wg sync.WaitGroup
-	var pages []requester.Page
+		page, err := requester.Head(ctx, url, httpclient)
 
-	// dynamically determine the worker pool size, we'll either set a default or
-	// use a smaller value if the number of tasks is smaller than the default.
-	workerPool := defaultWorkerPool
-	if toProcess < defaultWorkerPool {
-		workerPool = toProcess
+		// note: not every server supports HEAD requests, in which case we fall
+		// back to a GET request (which will stop once it knows what it is).
+		if err == nil && (page.Status == http.StatusMethodNotAllowed || page.Status == http.StatusNotImplemented) {
+			page, err = requester.GetHTML(ctx, url, v, httpclient)
+		}
+
+		// note: a HEAD response has no body to sniff, so a resource served without
+		// a Content-Type would otherwise be mistaken for a HTML page (as an empty
+		// media type is given the benefit of the doubt). The extension already
+		// told us it isn't one, so we'll trust it for the media type too.
+		if err == nil && page.ContentType == "" {
+			page.ContentType = extensionType(url)
+		}
+
+		return page, err
+	})
+}
+
+// extensionType returns the media type associated with the extension of the
+// given URL's path.
+//
+// note: not every extension we know to be a resource has a registered media
+// type (that depends on the system's mime.types files), in which case it's
+// reported as arbitrary binary data.
+func extensionType(rawurl string) string {
+	const unknown = "application/octet-stream"
+
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return unknown
 	}
 
-	startTime := time.Now()
-	tasks := make(chan string, workerPool)
-
-	// spin up our worker pool as goroutines awaiting tasks to be processed
-	for i := 0; i < workerPool; i++ {
-		wg.Add(1)
-
-		go func(i int) {
-			defer wg.Done()
-
-			for url := range tasks {
-				page, err := requester.Get(url, httpclient)
-				if err != nil {
-					instr.Logger.Warn(err)
-					continue
-				}
-				trackedURLs.Store(url, true)
-				counter++
-
-				// we use a mutex to ensure thread safety, not only for the correctness
-				// of the program but also because the Go language can trigger a panic!
-				mutex.Lock()
-				pages = append(pages, page)
-				mutex.Unlock()
-			}
-		}(i)
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(u.Path)))
+	if err != nil {
+		return unknown
 	}
+	return mediaType
+}
 
-	for _, url := range mappedPage.Anchors {
-		// check anchor within the given page hasn't already been processed
-		//
-		// originally I had the check for the Load within the goroutine itself, but
-		// there is a possible race condition concern due to context switching. so
-		// it's easier to reason about the logic when this check is outside.
-		if _, ok := trackedURLs.Load(url); !ok {
-			tasks <- url
+// fetch makes a request (unless robots.txt disallows it) and records any
+// failure on the returned page.
+func fetch(ctx context.Context, url string, instr *instrumentator.Instr, request func() (requester.Page, error)) requester.Page {
+	if !Allowed(url) {
+		return requester.Page{URL: url, Err: robots.ErrBlocked}
+	}
+
+	page, err := request()
+	if err != nil {
+		// a cancelled request isn't worth warning about, as it's expected when the
+		// user interrupts the crawl.
+		if ctx.Err() == nil {
+			instr.Logger.Warn(err)
 		}
+		page.Err = err
 	}
 
-	// go routines stay 'open' and blocking this function from finishing until we
-	// close the tasks channel
-	close(tasks)
+	return page
+}
+
+// Progress displays how many of the anchors found in the given page were
+// queued to be crawled.
+func Progress(mappedPage mapper.Page, queued int) {
+	// avoid printing to stdout if user has requested json/dot formatted output
+	if json || dot {
+		return
+	}
+
+	toProcess := len(mappedPage.Anchors)
 
-	wg.Wait()
+	fmt.Println("-------------------------")
+	fmt.Println(mappedPage.URL)
+	fmt.Printf("Contains %s URLs to crawl\n", formatter.Red(toProcess))
 
 	// we'll colourize the output so we can see at a glance what's happening...
 	//
-	// red: we request the full number of URLs
-	// yellow: we requested less than expected (as duplicates were found)
-	// green: we requested zero URLs (as duplicates were found)
+	// red: we queued the full number of URLs
+	// yellow: we queued less than expected (as duplicates were found)
+	// green: we queued zero URLs (as duplicates were found)
 	counterOut := formatter.Red(strconv.Itoa(toProcess))
 	msg := ""
-	if counter < toProcess {
-		counterOut = formatter.Yellow(counter)
+	if queued < toProcess {
+		counterOut = formatter.Yellow(queued)
 	}
-	if counter == 0 {
-		counterOut = formatter.Green(counter)
+	if queued == 0 {
+		counterOut = formatter.Green(queued)
 		msg = formatter.Green("(no pages requested)")
 	}
 
-	// avoid printing to stdout if user has requested json/dot formatted output
-	if !json && !dot {
-		fmt.Printf("Crawled %s URLs %s\n\n", counterOut, msg)
-	}
-
-	instr.Logger.Debug("time spent crawling:", time.Since(startTime))
-
-	return pages
+	fmt.Printf("Queued %s URLs %s\n\n", counterOut, msg)
 }
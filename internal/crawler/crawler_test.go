@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+func TestFollow(t *testing.T) {
+	defer Init(false, false, nil, RobotsMetaReport)
+
+	page := mapper.Page{
+		Anchors:      mapper.Assets{"http://www.example.com/about", "http://www.example.com/login"},
+		NoFollowURLs: mapper.Assets{"http://www.example.com/login"},
+	}
+
+	testCases := []struct {
+		name     string
+		mode     RobotsMeta
+		noFollow bool
+		expected mapper.Assets
+	}{
+		{"report", RobotsMetaReport, true, page.Anchors},
+		{"obey", RobotsMetaObey, false, mapper.Assets{"http://www.example.com/about"}},
+		{"obey nofollow page", RobotsMetaObey, true, nil},
+	}
+
+	for _, tc := range testCases {
+		Init(false, false, nil, tc.mode)
+
+		p := page
+		p.NoFollow = tc.noFollow
+
+		actual := Follow(p)
+		if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestFetchWithoutContentType(t *testing.T) {
+	// note: the Content-Type header is removed entirely (rather than left
+	// empty), as net/http would otherwise sniff one from the body.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte("<html><body>not really</body></html>"))
+	}))
+	defer server.Close()
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/photo.png", "image/png"},
+		{"/about", "text/html"},
+	}
+
+	for _, tc := range testCases {
+		page := Fetch(context.Background(), server.URL+tc.path, requester.Validators{}, server.Client(), &instr)
+
+		if page.ContentType != tc.expected {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.path, tc.expected, page.ContentType)
+		}
+	}
+}
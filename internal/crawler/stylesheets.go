@@ -0,0 +1,134 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/parser"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+// Stylesheets requests the stylesheets of the pages we crawl and extracts the
+// resources they reference (e.g. fonts, background images and other
+// stylesheets via @import).
+//
+// Most pages on a site share the same stylesheets, and so each stylesheet is
+// only requested once per crawl (no matter how many pages link to it) with the
+// result being cached for every other page.
+//
+// note: stylesheets don't count towards the -max-pages or -max-bytes limits,
+// as they're an asset of the pages being crawled rather than a page.
+type Stylesheets struct {
+	httpclient requester.HTTPClient
+	instr      *instrumentator.Instr
+
+	mutex   sync.Mutex
+	scanned map[string]*stylesheet
+}
+
+// stylesheet is the result of scanning a single stylesheet, where done is
+// closed once the result is available (so that concurrent workers wanting the
+// same stylesheet wait for the first request rather than making their own).
+type stylesheet struct {
+	done     chan struct{}
+	assets   []string
+	imports  []string
+	external []string
+}
+
+// NewStylesheets returns an empty cache of scanned stylesheets.
+func NewStylesheets(httpclient requester.HTTPClient, instr *instrumentator.Instr) *Stylesheets {
+	return &Stylesheets{
+		httpclient: httpclient,
+		instr:      instr,
+		scanned:    map[string]*stylesheet{},
+	}
+}
+
+// Scan returns the resources referenced by the given stylesheets, including
+// those referenced by any stylesheets they (directly or indirectly) import,
+// with the resources on hosts we don't crawl being returned separately.
+//
+// note: imports are followed breadth first with their own visited set, rather
+// than recursively, so that stylesheets importing each other can't lead to a
+// worker waiting on a result it's meant to be producing.
+func (s *Stylesheets) Scan(ctx context.Context, urls []string) ([]string, []string) {
+	var assets []string
+	var external []string
+
+	visited := map[string]bool{}
+	queue := append([]string{}, urls...)
+
+	for len(queue) > 0 {
+		url := queue[0]
+		queue = queue[1:]
+
+		if visited[url] {
+			continue
+		}
+		visited[url] = true
+
+		sheet := s.stylesheet(ctx, url)
+		assets = append(assets, sheet.assets...)
+		external = append(external, sheet.external...)
+		queue = append(queue, sheet.imports...)
+	}
+
+	return assets, external
+}
+
+// stylesheet returns the cached result for a stylesheet, requesting and
+// scanning it if this is the first time it has been seen.
+func (s *Stylesheets) stylesheet(ctx context.Context, url string) *stylesheet {
+	s.mutex.Lock()
+	sheet, ok := s.scanned[url]
+	if ok {
+		s.mutex.Unlock()
+
+		select {
+		case <-sheet.done:
+			return sheet
+		case <-ctx.Done():
+			return &stylesheet{}
+		}
+	}
+
+	sheet = &stylesheet{done: make(chan struct{})}
+	s.scanned[url] = sheet
+	s.mutex.Unlock()
+
+	defer close(sheet.done)
+
+	log := s.instr.Logger.WithFields(logrus.Fields{"url": url})
+
+	// note: unlike a page, the body of a stylesheet is always downloaded.
+	page := fetch(ctx, url, s.instr, func() (requester.Page, error) {
+		return requester.Get(ctx, url, s.httpclient)
+	})
+	if page.Err != nil || page.Status != 200 {
+		log.Debug("STYLESHEET_FAILED")
+		return sheet
+	}
+
+	// a server that doesn't say what it's serving gets the benefit of the doubt,
+	// but we don't scan a response that's clearly not CSS (e.g. a HTML error page
+	// served with a 200 status).
+	if page.Header.Get("Content-Type") != "" && page.ContentType != "text/css" {
+		log.Debug("STYLESHEET_INVALID")
+		return sheet
+	}
+
+	// relative URLs within a stylesheet are relative to the stylesheet itself
+	// (i.e. after following any redirects) rather than the page using it.
+	stylesheetURL := page.FinalURL
+	if stylesheetURL == "" {
+		stylesheetURL = url
+	}
+
+	sheet.assets, sheet.imports, sheet.external = parser.StylesheetURLs(string(page.Body), stylesheetURL)
+	log.Debug("STYLESHEET_SCANNED")
+
+	return sheet
+}
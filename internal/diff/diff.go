@@ -0,0 +1,278 @@
+package diff
+
+// The diff package compares the results of two crawls (i.e. the -json output)
+// so that the effect of a deploy on a site's structure can be reviewed.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+// fingerprintPattern matches a content hash within a filename, such as the
+// `d02777fd` in `main.d02777fd.css` or `app-3f2a9b1c.js`.
+var fingerprintPattern = regexp.MustCompile(`[.\-_]([0-9a-fA-F]{6,64})(\.[0-9a-zA-Z]+)$`)
+
+// Diff represents the differences between two crawls.
+type Diff struct {
+	AddedPages   []string
+	RemovedPages []string
+	Pages        []Page
+}
+
+// Page represents the differences for a page that appears in both crawls.
+//
+// ChangedAssets are the static assets (e.g. stylesheets, scripts and images)
+// that were replaced by a new version of the same file (i.e. their fingerprint
+// changed), whereas AddedAssets and RemovedAssets are the remaining differences.
+type Page struct {
+	URL           string
+	AddedLinks    []string      `json:",omitempty"`
+	RemovedLinks  []string      `json:",omitempty"`
+	ChangedAssets []AssetChange `json:",omitempty"`
+	AddedAssets   []string      `json:",omitempty"`
+	RemovedAssets []string      `json:",omitempty"`
+	Status        *StatusChange `json:",omitempty"`
+}
+
+// AssetChange represents a fingerprinted asset that was replaced.
+type AssetChange struct {
+	Old string
+	New string
+}
+
+// StatusChange represents a page whose status (or error) changed.
+type StatusChange struct {
+	Old      int
+	New      int
+	OldError string `json:",omitempty"`
+	NewError string `json:",omitempty"`
+}
+
+// Empty reports whether there are no differences.
+func (d Diff) Empty() bool {
+	return len(d.AddedPages) == 0 && len(d.RemovedPages) == 0 && len(d.Pages) == 0
+}
+
+// pageFields are the fields of a page that the -json output has always included
+// (even for crawls saved by earlier versions), and which are enough to tell a
+// page apart from the other JSON output (e.g. a broken link also has a URL).
+var pageFields = []string{"URL", "Anchors"}
+
+// Load reads the -json output of a crawl (including the -check -json output,
+// in which case the broken URLs are ignored).
+//
+// note: other output can also be a JSON array of objects with a URL field (e.g.
+// the broken links reported by -check), which would quietly unmarshal into a
+// set of empty pages and produce a meaningless diff, and so every entry must
+// have the fields of a page.
+func Load(path string) ([]mapper.Page, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var checked struct {
+		Pages json.RawMessage
+	}
+	if json.Unmarshal(b, &checked) == nil && checked.Pages != nil {
+		b = checked.Pages
+	}
+
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: expected the -json output of a crawl: %w", path, err)
+	}
+
+	for i, entry := range entries {
+		for _, field := range pageFields {
+			if _, ok := entry[field]; !ok {
+				return nil, fmt.Errorf("%s: expected the -json output of a crawl, but entry %d has no %s field", path, i, field)
+			}
+		}
+	}
+
+	var pages []mapper.Page
+	if err := json.Unmarshal(b, &pages); err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// Compare returns the differences between the old and new crawls.
+func Compare(old, new []mapper.Page) Diff {
+	var d Diff
+
+	oldPages := index(old)
+	newPages := index(new)
+
+	for u := range newPages {
+		if _, ok := oldPages[u]; !ok {
+			d.AddedPages = append(d.AddedPages, u)
+		}
+	}
+
+	for u, oldPage := range oldPages {
+		newPage, ok := newPages[u]
+		if !ok {
+			d.RemovedPages = append(d.RemovedPages, u)
+			continue
+		}
+
+		if p, changed := comparePage(oldPage, newPage); changed {
+			d.Pages = append(d.Pages, p)
+		}
+	}
+
+	sort.Strings(d.AddedPages)
+	sort.Strings(d.RemovedPages)
+	sort.Slice(d.Pages, func(i, j int) bool {
+		return d.Pages[i].URL < d.Pages[j].URL
+	})
+
+	return d
+}
+
+// index maps each page by its URL.
+func index(pages []mapper.Page) map[string]mapper.Page {
+	m := map[string]mapper.Page{}
+	for _, page := range pages {
+		m[page.URL] = page
+	}
+	return m
+}
+
+// comparePage returns the differences between two versions of the same page.
+func comparePage(old, new mapper.Page) (Page, bool) {
+	p := Page{URL: old.URL}
+
+	p.AddedLinks, p.RemovedLinks = difference(old.Anchors, new.Anchors)
+
+	added, removed := difference(assets(old), assets(new))
+	p.ChangedAssets, p.AddedAssets, p.RemovedAssets = fingerprinted(added, removed)
+
+	oldStatus, newStatus := status(old), status(new)
+	if oldStatus != newStatus || old.Error != new.Error {
+		p.Status = &StatusChange{Old: oldStatus, New: newStatus, OldError: old.Error, NewError: new.Error}
+	}
+
+	changed := len(p.AddedLinks) > 0 || len(p.RemovedLinks) > 0 ||
+		len(p.ChangedAssets) > 0 || len(p.AddedAssets) > 0 || len(p.RemovedAssets) > 0 ||
+		p.Status != nil
+
+	return p, changed
+}
+
+// assets returns every static asset a page depends on.
+func assets(page mapper.Page) mapper.Assets {
+	var all mapper.Assets
+	for _, class := range []mapper.Assets{page.Links, page.Scripts, page.Images, page.Media, page.Iframes, page.Objects, page.CSSAssets} {
+		all = append(all, class...)
+	}
+	return all
+}
+
+// status returns the status of a page.
+//
+// note: the -json output only started including the status once non 200 pages
+// were included in the results, so a successful page without a status from an
+// older crawl is treated as a 200.
+func status(page mapper.Page) int {
+	if page.Status == 0 && page.Error == "" {
+		return 200
+	}
+	return page.Status
+}
+
+// difference returns the (sorted) values that were added to and removed from
+// the old collection.
+func difference(old, new mapper.Assets) (added []string, removed []string) {
+	oldSet := map[string]bool{}
+	for _, v := range old {
+		oldSet[v] = true
+	}
+
+	newSet := map[string]bool{}
+	for _, v := range new {
+		newSet[v] = true
+		if !oldSet[v] {
+			added = append(added, v)
+		}
+	}
+
+	for _, v := range old {
+		if !newSet[v] {
+			removed = append(removed, v)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// fingerprinted pairs up the added and removed assets that are different
+// versions of the same file, returning them separately from the assets that
+// were genuinely added or removed.
+func fingerprinted(added, removed []string) (changed []AssetChange, stillAdded []string, stillRemoved []string) {
+	removedByKey := map[string][]string{}
+	for _, asset := range removed {
+		key := fingerprintKey(asset)
+		removedByKey[key] = append(removedByKey[key], asset)
+	}
+
+	paired := map[string]bool{}
+	for _, asset := range added {
+		key := fingerprintKey(asset)
+		if candidates := removedByKey[key]; len(candidates) > 0 {
+			changed = append(changed, AssetChange{Old: candidates[0], New: asset})
+			removedByKey[key] = candidates[1:]
+			paired[candidates[0]] = true
+			continue
+		}
+		stillAdded = append(stillAdded, asset)
+	}
+
+	for _, asset := range removed {
+		if !paired[asset] {
+			stillRemoved = append(stillRemoved, asset)
+		}
+	}
+
+	return changed, stillAdded, stillRemoved
+}
+
+// fingerprintKey strips the fingerprint from an asset URL (either a content
+// hash in the filename or a query string such as `?v=123`), meaning different
+// versions of the same file share the same key.
+func fingerprintKey(asset string) string {
+	u, err := url.Parse(asset)
+	if err != nil {
+		return asset
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	dir, file := path.Split(u.Path)
+	if m := fingerprintPattern.FindStringSubmatchIndex(file); m != nil {
+		hash := file[m[2]:m[3]]
+
+		// a hash will almost always contain a digit, which stops us mistaking a
+		// word that happens to only use the letters a-f (e.g. `-facade`) as one.
+		if strings.ContainsAny(hash, "0123456789") {
+			file = file[:m[0]] + file[m[4]:m[5]]
+		}
+	}
+	u.Path = dir + file
+
+	return u.String()
+}
@@ -0,0 +1,143 @@
+package diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+func TestCompare(t *testing.T) {
+	old := []mapper.Page{
+		{
+			URL:     "http://www.example.com/",
+			Anchors: mapper.Assets{"http://www.example.com/a", "http://www.example.com/b"},
+			Links:   mapper.Assets{"http://www.example.com/main.d02777fd.css", "http://www.example.com/print.css"},
+			Scripts: mapper.Assets{"http://www.example.com/app.js?v=1"},
+		},
+		{URL: "http://www.example.com/a"},
+		{URL: "http://www.example.com/b", Status: 200},
+		{URL: "http://www.example.com/c", Status: 200},
+	}
+
+	new := []mapper.Page{
+		{
+			URL:     "http://www.example.com/",
+			Anchors: mapper.Assets{"http://www.example.com/a", "http://www.example.com/d"},
+			Links:   mapper.Assets{"http://www.example.com/main.5be3a9c1.css", "http://www.example.com/extra.css"},
+			Scripts: mapper.Assets{"http://www.example.com/app.js?v=2"},
+		},
+		{URL: "http://www.example.com/a", Status: 200},
+		{URL: "http://www.example.com/b", Status: 404},
+		{URL: "http://www.example.com/d", Status: 200},
+	}
+
+	d := Compare(old, new)
+
+	if len(d.AddedPages) != 1 || d.AddedPages[0] != "http://www.example.com/d" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/d"}, d.AddedPages)
+	}
+
+	if len(d.RemovedPages) != 1 || d.RemovedPages[0] != "http://www.example.com/c" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/c"}, d.RemovedPages)
+	}
+
+	// note: /a is unchanged because a missing status from an older crawl is a 200
+	if len(d.Pages) != 2 {
+		t.Fatalf("expected: %+v\ngot: %+v", 2, len(d.Pages))
+	}
+
+	home := d.Pages[0]
+
+	if len(home.AddedLinks) != 1 || home.AddedLinks[0] != "http://www.example.com/d" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/d"}, home.AddedLinks)
+	}
+
+	if len(home.RemovedLinks) != 1 || home.RemovedLinks[0] != "http://www.example.com/b" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/b"}, home.RemovedLinks)
+	}
+
+	expectedChanges := []AssetChange{
+		{Old: "http://www.example.com/app.js?v=1", New: "http://www.example.com/app.js?v=2"},
+		{Old: "http://www.example.com/main.d02777fd.css", New: "http://www.example.com/main.5be3a9c1.css"},
+	}
+	if len(home.ChangedAssets) != len(expectedChanges) {
+		t.Fatalf("expected: %+v\ngot: %+v", expectedChanges, home.ChangedAssets)
+	}
+	for i, change := range home.ChangedAssets {
+		if change != expectedChanges[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expectedChanges[i], change)
+		}
+	}
+
+	if len(home.AddedAssets) != 1 || home.AddedAssets[0] != "http://www.example.com/extra.css" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/extra.css"}, home.AddedAssets)
+	}
+
+	if len(home.RemovedAssets) != 1 || home.RemovedAssets[0] != "http://www.example.com/print.css" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/print.css"}, home.RemovedAssets)
+	}
+
+	b := d.Pages[1]
+	expectedStatus := StatusChange{Old: 200, New: 404}
+	if b.URL != "http://www.example.com/b" || b.Status == nil || *b.Status != expectedStatus {
+		t.Errorf("expected: %+v\ngot: %+v", expectedStatus, b.Status)
+	}
+}
+
+func TestFingerprintKey(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{"http://www.example.com/main.d02777fd.css", "http://www.example.com/main.css"},
+		{"http://www.example.com/js/app-3f2a9b1c.js", "http://www.example.com/js/app.js"},
+		{"http://www.example.com/app.js?v=123", "http://www.example.com/app.js"},
+		{"http://www.example.com/my-facade.css", "http://www.example.com/my-facade.css"},
+		{"http://www.example.com/main.css", "http://www.example.com/main.css"},
+	} {
+		if actual := fingerprintKey(tc.input); actual != tc.expected {
+			t.Errorf("expected: %+v\ngot: %+v", tc.expected, actual)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"crawl", `[{"Anchors":null,"Links":null,"Scripts":null,"URL":"http://www.example.com/","Status":200,"Depth":0}]`, ""},
+		{"empty crawl", `[]`, ""},
+		{"older crawl", `[{"Anchors":["http://www.example.com/a"],"Links":null,"Scripts":null,"URL":"http://www.example.com/"}]`, ""},
+		{"broken links", `[{"URL":"http://www.example.com/missing","Status":404,"Sources":["http://www.example.com/"]}]`, "entry 0 has no Anchors field"},
+		{"checked crawl", `{"Pages":[{"Anchors":null,"Links":null,"Scripts":null,"URL":"http://www.example.com/","Depth":0}],"Broken":[]}`, ""},
+		{"not an array", `{"URL":"http://www.example.com/"}`, "expected the -json output of a crawl"},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(dir, strings.Replace(tc.name, " ", "-", -1)+".json")
+		if err := ioutil.WriteFile(path, []byte(tc.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := Load(path)
+
+		if tc.expected == "" && err != nil {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.name, nil, err)
+		}
+		if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%s\nexpected: %+v\ngot: %+v", tc.name, tc.expected, err)
+		}
+	}
+}
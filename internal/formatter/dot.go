@@ -0,0 +1,346 @@
+package formatter
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+// DotConfig controls how much of the site is rendered by Dot, as graphviz
+// quickly becomes unusable for a site with hundreds of interlinked pages.
+//
+// Prefix limits the graph to pages whose path starts with it, and Depth limits
+// the graph to pages at most that many clicks from the entry page (0 for no
+// limit). Collapse merges the edges from pages in the same cluster to the same
+// target into a single (weighted) edge from the cluster.
+type DotConfig struct {
+	Collapse bool
+	Prefix   string
+	Depth    int
+}
+
+// the colour of a node indicates the status of the page.
+var dotColours = map[string]string{
+	"ok":           "palegreen",
+	"redirect":     "orange",
+	"client error": "lightcoral",
+	"server error": "red",
+	"failed":       "lightgrey",
+	"not crawled":  "white",
+}
+
+// map of template functions used when rendering the dot template.
+var fns = template.FuncMap{
+	"quote": func(s string) string {
+		return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+	},
+}
+
+const dotTmpl = `digraph sitemap {
+  {{- if .Compound}}
+  compound=true;{{end}}
+  node [style=filled];
+{{- range .Clusters}}
+
+  subgraph {{quote .ID}} {
+    label={{quote .Label}};
+    {{- range .Nodes}}
+    {{quote .URL}} [label={{quote .Label}}, fillcolor={{.Colour}}{{if .Style}}, style={{quote .Style}}{{end}}{{if .Border}}, color={{.Border}}{{end}}{{if .XLabel}}, xlabel={{quote .XLabel}}{{end}}];
+    {{- end}}
+  }
+{{- end}}
+{{range .Edges}}
+  {{quote .Source}} -> {{quote .Target}}{{if .Cluster}} [ltail={{quote .Cluster}}, label="{{.Weight}}", penwidth={{.PenWidth}}]{{end}};
+{{- end}}
+}
+`
+
+// dotGraph is the data rendered by the dot template.
+type dotGraph struct {
+	Compound bool
+	Clusters []*dotCluster
+	Edges    []dotEdge
+}
+
+// dotCluster groups the pages that share the same top-level path segment.
+type dotCluster struct {
+	ID    string
+	Label string
+	Nodes []dotNode
+}
+
+type dotNode struct {
+	URL    string
+	Label  string
+	Colour string
+	Style  string
+	Border string
+	XLabel string
+}
+
+// dotEdge is a link between two pages, or when collapsed (i.e. Cluster is set)
+// the links from Weight pages within a cluster to the same target.
+type dotEdge struct {
+	Source   string
+	Target   string
+	Cluster  string
+	Weight   int
+	PenWidth int
+}
+
+// Dot renders our results in dot format for use with graphviz
+//
+// pages are grouped into clusters by the first segment of their path (e.g.
+// `/posts`) and coloured by their status, while pages that were reached via a
+// chain of redirects (or that never resolved due to a redirect loop) are also
+// labelled so they stand out in the graph.
+//
+// a page can link to URLs that were never crawled (e.g. because the crawl hit
+// one of its limits), and those are rendered as dashed nodes so that the links
+// to them still appear in the graph.
+func Dot(results []mapper.Page, config DotConfig) string {
+	tmpl, err := template.New("digraph").Funcs(fns).Parse(dotTmpl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, dotData(results, config)); err != nil {
+		log.Fatal(err)
+	}
+
+	return output.String()
+}
+
+// dotData converts the results into clusters of nodes and the edges between
+// them, leaving out any page that doesn't satisfy the config.
+func dotData(results []mapper.Page, config DotConfig) dotGraph {
+	var graph dotGraph
+
+	crawled := map[string]bool{}
+	for _, page := range results {
+		crawled[page.URL] = true
+	}
+
+	var pages []mapper.Page
+	hosts := map[string]bool{}
+	for _, page := range results {
+		host, ok := dotIncluded(page.URL, page.Depth, config)
+		if !ok {
+			continue
+		}
+
+		pages = append(pages, page)
+		hosts[host] = true
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+
+	// note: a URL that was never crawled is one click further from the entry
+	// page than the page linking to it, and is subject to the same config.
+	var uncrawled []string
+	seen := map[string]bool{}
+	for _, page := range pages {
+		for _, target := range page.Anchors {
+			if crawled[target] || seen[target] {
+				continue
+			}
+			host, ok := dotIncluded(target, page.Depth+1, config)
+			if !ok {
+				continue
+			}
+
+			seen[target] = true
+			uncrawled = append(uncrawled, target)
+			hosts[host] = true
+		}
+	}
+	sort.Strings(uncrawled)
+
+	// every node belongs to a cluster, which is what edges are collapsed by.
+	clusters := map[string]*dotCluster{}
+	clusterOf := map[string]string{}
+	addNode := func(node dotNode) {
+		label, path := dotSegment(node.URL, len(hosts) > 1)
+
+		cluster, ok := clusters[label]
+		if !ok {
+			cluster = &dotCluster{ID: fmt.Sprintf("cluster_%d", len(clusters)), Label: label}
+			clusters[label] = cluster
+			graph.Clusters = append(graph.Clusters, cluster)
+		}
+
+		node.Label = path
+		cluster.Nodes = append(cluster.Nodes, node)
+		clusterOf[node.URL] = cluster.ID
+	}
+
+	for _, page := range pages {
+		addNode(dotNode{
+			URL:    page.URL,
+			Colour: dotColours[dotStatus(page)],
+			Border: dotBorder(page),
+			XLabel: dotXLabel(page),
+		})
+	}
+
+	for _, target := range uncrawled {
+		addNode(dotNode{
+			URL:    target,
+			Colour: dotColours["not crawled"],
+			Style:  "filled,dashed",
+		})
+	}
+
+	// collapsed edges are keyed by the source cluster and target, and the first
+	// page found in the cluster is used as the edge's tail.
+	type collapsed struct {
+		cluster string
+		target  string
+	}
+	var order []collapsed
+	merged := map[collapsed]*dotEdge{}
+
+	// note: edges to pages that were left out of the graph by the config are
+	// dropped (whereas an uncrawled URL has a node of its own).
+	for _, page := range pages {
+		for _, target := range page.Anchors {
+			if _, ok := clusterOf[target]; !ok || target == page.URL {
+				continue
+			}
+
+			edge := dotEdge{Source: page.URL, Target: target}
+
+			// graphviz can't clip an edge to a cluster that contains its head, so
+			// edges within a cluster are never collapsed.
+			source := clusterOf[page.URL]
+			if !config.Collapse || clusterOf[target] == source {
+				graph.Edges = append(graph.Edges, edge)
+				continue
+			}
+
+			key := collapsed{source, target}
+			if existing, ok := merged[key]; ok {
+				existing.Weight++
+				continue
+			}
+			edge.Weight = 1
+			merged[key] = &edge
+			order = append(order, key)
+		}
+	}
+
+	for _, key := range order {
+		edge := *merged[key]
+		if edge.Weight > 1 {
+			edge.Cluster = key.cluster
+			edge.PenWidth = 1 + edge.Weight/10
+			graph.Compound = true
+		}
+		graph.Edges = append(graph.Edges, edge)
+	}
+
+	return graph
+}
+
+// dotIncluded reports whether a URL at the given depth satisfies the config,
+// along with the URL's host.
+func dotIncluded(rawurl string, depth int, config DotConfig) (string, bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", false
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, config.Prefix) {
+		return "", false
+	}
+	if config.Depth > 0 && depth > config.Depth {
+		return "", false
+	}
+
+	return u.Host, true
+}
+
+// dotSegment returns the cluster label for a URL (the first segment of its
+// path, or `/` for pages at the root of the site) along with the path that is
+// used as the node's label.
+//
+// note: the host is only included when the results span multiple hosts (e.g.
+// subdomains) as otherwise it would needlessly lengthen every label.
+func dotSegment(rawurl string, withHost bool) (string, string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "/", rawurl
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	segment := "/"
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if parts[0] != "" && (len(parts) > 1 || !strings.Contains(parts[0], ".")) {
+		segment = "/" + parts[0]
+	}
+
+	if withHost {
+		segment = u.Host + segment
+		path = u.Host + path
+	}
+
+	return segment, path
+}
+
+// dotStatus categorises a page by its status.
+func dotStatus(page mapper.Page) string {
+	switch {
+	case page.Error != "":
+		return "failed"
+	case page.Status >= 500:
+		return "server error"
+	case page.Status >= 400:
+		return "client error"
+	case page.Status >= 300 || len(page.Redirects) > 0:
+		return "redirect"
+	default:
+		return "ok"
+	}
+}
+
+// dotBorder highlights a redirect loop (or a chain of redirects).
+func dotBorder(page mapper.Page) string {
+	switch {
+	case page.RedirectLoop:
+		return "red"
+	case len(page.Redirects) > 1:
+		return "orange"
+	}
+	return ""
+}
+
+// dotXLabel describes a redirect loop (or a chain of redirects).
+func dotXLabel(page mapper.Page) string {
+	switch {
+	case page.RedirectLoop:
+		return "redirect loop"
+	case len(page.Redirects) > 1:
+		return fmt.Sprintf("%d redirects", len(page.Redirects))
+	}
+	return ""
+}
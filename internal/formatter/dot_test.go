@@ -0,0 +1,160 @@
+package formatter
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+var dotResults = []mapper.Page{
+	{URL: "http://www.example.com/", Status: 200, Anchors: mapper.Assets{"http://www.example.com/posts/a", "http://www.example.com/posts/b", "http://www.example.com/about.html"}},
+	{URL: "http://www.example.com/about.html", Status: 200, Depth: 1},
+	{URL: "http://www.example.com/posts/a", Status: 200, Depth: 1, Anchors: mapper.Assets{"http://www.example.com/", "http://www.example.com/posts/b", "http://www.example.com/tags/go"}},
+	{URL: "http://www.example.com/posts/b", Status: 404, Depth: 1, Anchors: mapper.Assets{"http://www.example.com/"}},
+	{URL: "http://www.example.com/tags/go", Status: 500, Depth: 2, Anchors: mapper.Assets{"http://www.example.com/"}},
+}
+
+func TestDotClusters(t *testing.T) {
+	graph := dotData(dotResults, DotConfig{})
+
+	expected := map[string][]string{
+		"/":      {"/", "/about.html"},
+		"/posts": {"/posts/a", "/posts/b"},
+		"/tags":  {"/tags/go"},
+	}
+
+	if len(graph.Clusters) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", len(expected), len(graph.Clusters))
+	}
+
+	for _, cluster := range graph.Clusters {
+		var labels []string
+		for _, node := range cluster.Nodes {
+			labels = append(labels, node.Label)
+		}
+		if strings.Join(labels, ",") != strings.Join(expected[cluster.Label], ",") {
+			t.Errorf("%s expected: %+v\ngot: %+v", cluster.Label, expected[cluster.Label], labels)
+		}
+	}
+
+	colours := map[string]string{}
+	for _, cluster := range graph.Clusters {
+		for _, node := range cluster.Nodes {
+			colours[node.Label] = node.Colour
+		}
+	}
+
+	for label, colour := range map[string]string{
+		"/":        "palegreen",
+		"/posts/b": "lightcoral",
+		"/tags/go": "red",
+	} {
+		if colours[label] != colour {
+			t.Errorf("%s expected: %+v\ngot: %+v", label, colour, colours[label])
+		}
+	}
+
+	if len(graph.Edges) != 8 || graph.Compound {
+		t.Errorf("expected: %+v edges\ngot: %+v", 8, graph.Edges)
+	}
+}
+
+func TestDotCollapse(t *testing.T) {
+	graph := dotData(dotResults, DotConfig{Collapse: true})
+
+	// the three links back to the entry page are from two different clusters,
+	// and only the two from /posts can be merged.
+	var collapsed []dotEdge
+	for _, edge := range graph.Edges {
+		if edge.Cluster != "" {
+			collapsed = append(collapsed, edge)
+		}
+	}
+
+	if len(collapsed) != 1 || collapsed[0].Target != "http://www.example.com/" || collapsed[0].Weight != 2 {
+		t.Errorf("expected: %+v\ngot: %+v", "a single edge from /posts to / with a weight of 2", collapsed)
+	}
+
+	if len(graph.Edges) != 7 || !graph.Compound {
+		t.Errorf("expected: %+v edges\ngot: %+v", 7, graph.Edges)
+	}
+
+	if output := Dot(dotResults, DotConfig{Collapse: true}); !strings.Contains(output, "compound=true;") || !strings.Contains(output, `ltail="cluster_1"`) {
+		t.Errorf("expected: %+v\ngot: %+v", "a compound graph", output)
+	}
+}
+
+func TestDotLimits(t *testing.T) {
+	for _, tc := range []struct {
+		config   DotConfig
+		expected int
+	}{
+		{DotConfig{Prefix: "/posts"}, 2},
+		{DotConfig{Prefix: "/posts/a"}, 1},
+		{DotConfig{Depth: 1}, 4},
+	} {
+		var nodes int
+		for _, cluster := range dotData(dotResults, tc.config).Clusters {
+			nodes += len(cluster.Nodes)
+		}
+
+		if nodes != tc.expected {
+			t.Errorf("%+v expected: %+v\ngot: %+v", tc.config, tc.expected, nodes)
+		}
+	}
+
+	// edges to pages that were left out of the graph are dropped
+	for _, edge := range dotData(dotResults, DotConfig{Prefix: "/posts"}).Edges {
+		if !strings.Contains(edge.Source, "/posts") || !strings.Contains(edge.Target, "/posts") {
+			t.Errorf("unexpected edge: %+v", edge)
+		}
+	}
+}
+
+func TestDotUncrawled(t *testing.T) {
+	results := []mapper.Page{
+		{URL: "http://www.example.com/", Status: 200, Anchors: mapper.Assets{"http://www.example.com/posts/a", "http://www.example.com/about"}},
+		{URL: "http://www.example.com/posts/a", Status: 200, Depth: 1, Anchors: mapper.Assets{"http://www.example.com/posts/b"}},
+	}
+
+	for _, tc := range []struct {
+		config   DotConfig
+		expected []string
+	}{
+		{DotConfig{}, []string{"http://www.example.com/about", "http://www.example.com/posts/b"}},
+		{DotConfig{Prefix: "/posts"}, []string{"http://www.example.com/posts/b"}},
+		{DotConfig{Depth: 1}, []string{"http://www.example.com/about"}},
+	} {
+		graph := dotData(results, tc.config)
+
+		var actual []string
+		for _, cluster := range graph.Clusters {
+			for _, node := range cluster.Nodes {
+				if node.Style == "filled,dashed" && node.Colour == dotColours["not crawled"] {
+					actual = append(actual, node.URL)
+				}
+			}
+		}
+		sort.Strings(actual)
+
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%+v expected: %+v\ngot: %+v", tc.config, tc.expected, actual)
+		}
+
+		// the links to the uncrawled pages are kept
+		var edges int
+		for _, edge := range graph.Edges {
+			for _, target := range tc.expected {
+				if edge.Target == target {
+					edges++
+				}
+			}
+		}
+
+		if edges != len(tc.expected) {
+			t.Errorf("%+v expected: %+v edges\ngot: %+v", tc.config, len(tc.expected), graph.Edges)
+		}
+	}
+}
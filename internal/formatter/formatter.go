@@ -1,15 +1,19 @@
 package formatter
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
-	"text/template"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/integralist/go-web-crawler/internal/cache"
+	"github.com/integralist/go-web-crawler/internal/checker"
+	"github.com/integralist/go-web-crawler/internal/diff"
 	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
 )
 
 // Red provides coloured output for text given to a string format function.
@@ -21,47 +25,324 @@ var Green = color.New(color.FgGreen).SprintFunc()
 // Yellow provides coloured output for text given to a string format function.
 var Yellow = color.New(color.FgYellow).SprintFunc()
 
-// map of template functions that enable us to identify the final item within a
-// collection being iterated over.
-var fns = template.FuncMap{
-	"plus1": func(x int) int {
-		return x + 1
-	},
+// Pretty cleanly formats a given data structure for easily reading.
+func Pretty(v interface{}) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// Summary holds crawl-wide information that isn't tied to any single page.
+//
+// Broken is only populated when the crawl was run in check mode (as indicated
+// by Checked), and Sitemaps, CSV and Report hold the paths of any sitemap
+// files, CSV files and HTML report written.
+//
+// Seeded is the number of URLs found in the site's existing sitemaps, Orphans
+// are the pages listed in those sitemaps that aren't linked to, and Unlisted
+// are the linked pages that are missing from them.
+//
+// Changes is only populated when a cache from a previous crawl was used.
+type Summary struct {
+	StopReason string           `json:",omitempty"`
+	Excluded   map[string]int   `json:",omitempty"`
+	Checked    bool             `json:",omitempty"`
+	Broken     []checker.Result `json:",omitempty"`
+	Sitemaps   []string         `json:",omitempty"`
+	Seeded     int              `json:",omitempty"`
+	Orphans    []string         `json:",omitempty"`
+	Unlisted   []string         `json:",omitempty"`
+	Changes    *cache.Changes   `json:",omitempty"`
+	CSV        []string         `json:",omitempty"`
+	Report     string           `json:",omitempty"`
 }
 
-// Dot renders our results in dot format for use with graphviz
-func Dot(results []mapper.Page) {
-	dotTmpl := `digraph sitemap { {{- range .}}
-  "{{.URL}}"
-    -> { {{- $n := len .Anchors}}{{range  $i, $v := .Anchors}}
-      "{{.}}"{{if eq (plus1 $i) $n}}{{else}},{{end}}{{end}}
-    }{{end}}
-}`
+// CheckedResults is the -json output in check mode, which lists the broken URLs
+// alongside the crawled pages.
+type CheckedResults struct {
+	Pages  []mapper.Page
+	Broken []checker.Result
+}
 
-	tmpl, err := template.New("digraph").Funcs(fns).Parse(dotTmpl)
-	if err != nil {
-		log.Fatal(err)
+// Standard is the default formatted output for the program
+func Standard(results []mapper.Page, summary Summary, startTime time.Time) {
+	var blocked []mapper.Page
+	var failed []mapper.Page
+	var loops []mapper.Page
+	var chains []mapper.Page
+	var non200 []mapper.Page
+	var noIndex []mapper.Page
+	var noFollow []mapper.Page
+	for _, page := range results {
+		// note: the robots directives are reported regardless of which (if any) of
+		// the other groups a page falls into.
+		if page.NoIndex {
+			noIndex = append(noIndex, page)
+		}
+		if page.NoFollow || len(page.NoFollowURLs) > 0 {
+			noFollow = append(noFollow, page)
+		}
+
+		switch {
+		case page.Blocked:
+			blocked = append(blocked, page)
+		case page.RedirectLoop:
+			loops = append(loops, page)
+		case page.Error != "":
+			failed = append(failed, page)
+		case page.Status != 200:
+			non200 = append(non200, page)
+		case len(page.Redirects) > 1:
+			chains = append(chains, page)
+		}
+	}
+
+	fmt.Printf("-------------------------\n\nNumber of URLs crawled and processed: %s\n", Green(len(results)))
+
+	if len(failed) > 0 {
+		fmt.Printf("Number of URLs that failed: %s\n", Red(len(failed)))
+		for _, page := range failed {
+			fmt.Printf("  %s (%s)\n", page.URL, Red(page.Error))
+		}
+	}
+
+	if len(non200) > 0 {
+		fmt.Printf("Number of URLs with a non 200 status: %s\n", Red(len(non200)))
+		for _, page := range non200 {
+			fmt.Printf("  %s (%s)\n", page.URL, Red(page.Status))
+		}
+	}
+
+	if len(blocked) > 0 {
+		fmt.Printf("Number of URLs blocked by robots: %s\n", Yellow(len(blocked)))
+		for _, page := range blocked {
+			fmt.Printf("  %s\n", page.URL)
+		}
+	}
+
+	if len(noIndex) > 0 {
+		fmt.Printf("Number of pages marked noindex: %s\n", Yellow(len(noIndex)))
+		for _, page := range noIndex {
+			fmt.Printf("  %s\n", page.URL)
+		}
+	}
+
+	if len(noFollow) > 0 {
+		fmt.Printf("Number of pages with nofollow links: %s\n", Yellow(len(noFollow)))
+		for _, page := range noFollow {
+			if page.NoFollow {
+				fmt.Printf("  %s (%s)\n", page.URL, Yellow("all links"))
+				continue
+			}
+			fmt.Printf("  %s (%s)\n", page.URL, Yellow(len(page.NoFollowURLs)))
+		}
+	}
+
+	if len(loops) > 0 {
+		fmt.Printf("Number of redirect loops: %s\n", Red(len(loops)))
+		for _, page := range loops {
+			fmt.Printf("  %s\n", redirectChain(page))
+		}
+	}
+
+	if len(chains) > 0 {
+		fmt.Printf("Number of redirect chains (more than one hop): %s\n", Yellow(len(chains)))
+		for _, page := range chains {
+			fmt.Printf("  %s\n", redirectChain(page))
+		}
+	}
+
+	if len(summary.Excluded) > 0 {
+		var reasons []string
+		var total int
+		for reason, n := range summary.Excluded {
+			reasons = append(reasons, reason)
+			total += n
+		}
+		sort.Strings(reasons)
+
+		fmt.Printf("Number of URLs excluded: %s\n", Yellow(total))
+		for _, reason := range reasons {
+			fmt.Printf("  %s: %d\n", reason, summary.Excluded[reason])
+		}
+	}
+
+	if summary.Seeded > 0 {
+		fmt.Printf("Number of URLs found in sitemaps: %s\n", Green(summary.Seeded))
+
+		if len(summary.Orphans) > 0 {
+			fmt.Printf("Number of orphan pages (in a sitemap but not linked to): %s\n", Yellow(len(summary.Orphans)))
+			for _, url := range summary.Orphans {
+				fmt.Printf("  %s\n", url)
+			}
+		}
+
+		if len(summary.Unlisted) > 0 {
+			fmt.Printf("Number of pages missing from the sitemaps: %s\n", Yellow(len(summary.Unlisted)))
+			for _, url := range summary.Unlisted {
+				fmt.Printf("  %s\n", url)
+			}
+		}
+	}
+
+	if summary.Changes != nil {
+		changes := summary.Changes
+		fmt.Printf("Changes since the previous crawl: %s new, %s changed, %s unchanged, %s removed\n",
+			Green(len(changes.New)), Yellow(len(changes.Changed)), Green(len(changes.Unchanged)), Red(len(changes.Removed)))
+
+		for _, group := range []struct {
+			name string
+			urls []string
+		}{
+			{"new", changes.New},
+			{"changed", changes.Changed},
+			{"removed", changes.Removed},
+		} {
+			for _, url := range group.urls {
+				fmt.Printf("  %s: %s\n", group.name, url)
+			}
+		}
+	}
+
+	if summary.Checked {
+		Broken(summary.Broken)
+	}
+
+	for _, path := range summary.Sitemaps {
+		fmt.Printf("Sitemap written to: %s\n", Green(path))
 	}
 
-	var output bytes.Buffer
-	if err := tmpl.Execute(&output, results); err != nil {
-		log.Fatal(err)
+	for _, path := range summary.CSV {
+		fmt.Printf("CSV written to: %s\n", Green(path))
 	}
 
-	fmt.Println(output.String())
+	if summary.Report != "" {
+		fmt.Printf("Report written to: %s\n", Green(summary.Report))
+	}
+
+	if summary.StopReason != "" {
+		fmt.Printf("Crawl ended early: %s\n", Yellow(summary.StopReason))
+	}
+
+	fmt.Printf("Time: %s\n", Green(time.Since(startTime)))
 }
 
-// Pretty cleanly formats a given data structure for easily reading.
-func Pretty(v interface{}) {
-	b, err := json.MarshalIndent(v, "", "  ")
+// redirectChain describes each hop a page was redirected through, e.g.
+// `http://a/ (301) -> http://b/ (302) -> http://c/`
+func redirectChain(page mapper.Page) string {
+	var hops []string
+	for _, hop := range page.Redirects {
+		hops = append(hops, fmt.Sprintf("%s (%d)", hop.URL, hop.Status))
+	}
+
+	// a redirect loop never arrives at a final URL, so we finish with wherever
+	// the last hop was pointing (i.e. a URL we'd already visited).
+	last := page.URL
+	if page.FinalURL != "" {
+		last = page.FinalURL
+	}
+	if page.RedirectLoop && len(page.Redirects) > 0 {
+		last = location(page.Redirects[len(page.Redirects)-1])
+	}
+
+	return strings.Join(append(hops, last), " -> ")
+}
+
+// location resolves the (possibly relative) Location of a redirect.
+func location(hop requester.Redirect) string {
+	base, err := url.Parse(hop.URL)
+	if err != nil {
+		return hop.Location
+	}
+
+	location, err := base.Parse(hop.Location)
 	if err != nil {
-		fmt.Println(err)
+		return hop.Location
 	}
-	fmt.Println(string(b))
+
+	return location.String()
 }
 
-// Standard is the default formatted output for the program
-func Standard(results []mapper.Page, startTime time.Time) {
-	fmt.Printf("-------------------------\n\nNumber of URLs crawled and processed: %s\n", Green(len(results)))
-	fmt.Printf("Time: %s\n", Green(time.Since(startTime)))
+// Broken displays each broken URL along with every page that references it.
+func Broken(broken []checker.Result) {
+	if len(broken) == 0 {
+		fmt.Printf("Number of broken links: %s\n", Green(0))
+		return
+	}
+
+	fmt.Printf("Number of broken links: %s\n", Red(len(broken)))
+	for _, result := range broken {
+		reason := result.Error
+		if reason == "" {
+			reason = fmt.Sprintf("%d", result.Status)
+		}
+
+		fmt.Printf("  %s (%s)\n", result.URL, Red(reason))
+		for _, source := range result.Sources {
+			fmt.Printf("    found on: %s\n", source)
+		}
+	}
+}
+
+// Diff displays the differences between two crawls.
+//
+// note: the output is deliberately plain (a line per difference, prefixed with
+// + or -) so that it can be pasted into a pull request.
+func Diff(d diff.Diff) {
+	if d.Empty() {
+		fmt.Printf("No differences between the crawls\n")
+		return
+	}
+
+	if len(d.AddedPages) > 0 {
+		fmt.Printf("Number of pages added: %s\n", Green(len(d.AddedPages)))
+		for _, url := range d.AddedPages {
+			fmt.Printf("  + %s\n", url)
+		}
+	}
+
+	if len(d.RemovedPages) > 0 {
+		fmt.Printf("Number of pages removed: %s\n", Red(len(d.RemovedPages)))
+		for _, url := range d.RemovedPages {
+			fmt.Printf("  - %s\n", url)
+		}
+	}
+
+	if len(d.Pages) > 0 {
+		fmt.Printf("Number of pages changed: %s\n", Yellow(len(d.Pages)))
+	}
+
+	for _, page := range d.Pages {
+		fmt.Printf("\n%s\n", page.URL)
+
+		if page.Status != nil {
+			fmt.Printf("  status: %s -> %s\n", diffStatus(page.Status.Old, page.Status.OldError), diffStatus(page.Status.New, page.Status.NewError))
+		}
+		for _, url := range page.AddedLinks {
+			fmt.Printf("  + link: %s\n", url)
+		}
+		for _, url := range page.RemovedLinks {
+			fmt.Printf("  - link: %s\n", url)
+		}
+		for _, change := range page.ChangedAssets {
+			fmt.Printf("  ~ asset: %s -> %s\n", change.Old, change.New)
+		}
+		for _, url := range page.AddedAssets {
+			fmt.Printf("  + asset: %s\n", url)
+		}
+		for _, url := range page.RemovedAssets {
+			fmt.Printf("  - asset: %s\n", url)
+		}
+	}
+}
+
+// diffStatus describes the status of a page, preferring the error (if any) as
+// a page that failed to be requested has no status.
+func diffStatus(status int, err string) string {
+	if err != "" {
+		return err
+	}
+	return fmt.Sprintf("%d", status)
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
 )
 
 func TestDot(t *testing.T) {
@@ -37,18 +38,40 @@ func TestDot(t *testing.T) {
 	// because of this, and in the interest of time I opted to use strings.Fields
 	// instead as a quick win.
 
+	// note: none of the linked pages were crawled, so they're rendered as dashed
+	// nodes (each in the cluster for the first segment of its path).
+
 	output := strings.Fields(`digraph sitemap {
-	"http://www.example.com/"
-		-> {
-			"http://www.example.com/foo",
-			"http://www.example.com/bar",
-			"http://www.example.com/baz"
-		}
+  node [style=filled];
+
+  subgraph "cluster_0" {
+    label="/";
+    "http://www.example.com/" [label="/", fillcolor=palegreen];
+  }
+
+  subgraph "cluster_1" {
+    label="/bar";
+    "http://www.example.com/bar" [label="/bar", fillcolor=white, style="filled,dashed"];
+  }
+
+  subgraph "cluster_2" {
+    label="/baz";
+    "http://www.example.com/baz" [label="/baz", fillcolor=white, style="filled,dashed"];
+  }
+
+  subgraph "cluster_3" {
+    label="/foo";
+    "http://www.example.com/foo" [label="/foo", fillcolor=white, style="filled,dashed"];
+  }
+
+  "http://www.example.com/" -> "http://www.example.com/foo";
+  "http://www.example.com/" -> "http://www.example.com/bar";
+  "http://www.example.com/" -> "http://www.example.com/baz";
 }`)
 
-	actual := strings.Fields(Dot(input))
+	actual := strings.Fields(Dot(input, DotConfig{}))
 
-	if output[0] != actual[0] {
+	if strings.Join(output, " ") != strings.Join(actual, " ") {
 		t.Errorf("expected: %s\ngot: %s", output, actual)
 	}
 }
@@ -102,3 +125,34 @@ func TestPretty(t *testing.T) {
 		t.Errorf("expected: %s\ngot: %s", output, actual)
 	}
 }
+
+func TestRedirectChain(t *testing.T) {
+	input := mapper.Page{
+		URL: "http://www.example.com/c",
+		Redirects: []requester.Redirect{
+			{URL: "http://www.example.com/a", Status: 301, Location: "/b"},
+			{URL: "http://www.example.com/b", Status: 302, Location: "/c"},
+		},
+	}
+
+	output := "http://www.example.com/a (301) -> http://www.example.com/b (302) -> http://www.example.com/c"
+
+	if actual := redirectChain(input); actual != output {
+		t.Errorf("expected: %s\ngot: %s", output, actual)
+	}
+
+	loop := mapper.Page{
+		URL: "http://www.example.com/a",
+		Redirects: []requester.Redirect{
+			{URL: "http://www.example.com/a", Status: 301, Location: "/b"},
+			{URL: "http://www.example.com/b", Status: 301, Location: "/a"},
+		},
+		RedirectLoop: true,
+	}
+
+	output = "http://www.example.com/a (301) -> http://www.example.com/b (301) -> http://www.example.com/a"
+
+	if actual := redirectChain(loop); actual != output {
+		t.Errorf("expected: %s\ngot: %s", output, actual)
+	}
+}
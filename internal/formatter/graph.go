@@ -0,0 +1,426 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
+)
+
+// the types of edge (and the kind of node at the end of an edge that wasn't
+// crawled, e.g. a stylesheet or an image).
+const (
+	edgeAnchor     = "anchor"
+	edgeLink       = "link"
+	edgeStylesheet = "stylesheet"
+	edgeScript     = "script"
+	edgeImage      = "image"
+	edgeMedia      = "media"
+	edgeIframe     = "iframe"
+	edgeObject     = "object"
+	edgeForm       = "form"
+	edgeCSS        = "css"
+	nodePage       = "page"
+	nodeResource   = "resource"
+)
+
+// graphNode is a single node within the link graph.
+//
+// Crawled indicates the node is a crawled page, as only those have a status,
+// depth, content type, size and title (a page that was linked to but never
+// crawled, e.g. due to the -max-pages limit, is still a page node). A crawled
+// URL that turned out not to be a HTML document (e.g. a PDF) is a resource.
+type graphNode struct {
+	ID          string
+	Kind        string
+	Crawled     bool
+	Status      int
+	Depth       int
+	ContentType string
+	Size        int64
+	Title       string
+	Error       string
+}
+
+// graphEdge is a single (directed) edge within the link graph.
+type graphEdge struct {
+	Source string
+	Target string
+	Type   string
+}
+
+// graph converts the results into the nodes and edges of a link graph, which
+// is the basis of every graph export (other than Dot).
+//
+// note: the nodes are sorted (crawled pages first) so the output is stable.
+func graph(results []mapper.Page) ([]graphNode, []graphEdge) {
+	var nodes []graphNode
+	var edges []graphEdge
+
+	crawled := map[string]bool{}
+	for _, page := range results {
+		crawled[page.URL] = true
+	}
+
+	pages := append([]mapper.Page{}, results...)
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+
+	others := map[string]string{}
+	for _, page := range pages {
+		status := page.Status
+		if status == 0 && page.Error == "" {
+			status = 200
+		}
+
+		kind := nodePage
+		if !requester.IsHTML(page.ContentType) {
+			kind = nodeResource
+		}
+
+		nodes = append(nodes, graphNode{
+			ID:          page.URL,
+			Kind:        kind,
+			Crawled:     true,
+			Status:      status,
+			Depth:       page.Depth,
+			ContentType: page.ContentType,
+			Size:        page.Size,
+			Title:       page.Title,
+			Error:       page.Error,
+		})
+
+		// note: a <link> can be many things other than a stylesheet (e.g. a
+		// canonical URL, an icon or a preconnect hint), and so only the links to
+		// the page's stylesheets are typed as such.
+		stylesheets := map[string]bool{}
+		for _, url := range page.Stylesheets {
+			stylesheets[url] = true
+		}
+
+		var stylesheetLinks mapper.Assets
+		var otherLinks mapper.Assets
+		for _, url := range page.Links {
+			if stylesheets[url] {
+				stylesheetLinks = append(stylesheetLinks, url)
+			} else {
+				otherLinks = append(otherLinks, url)
+			}
+		}
+
+		for _, group := range []struct {
+			edgeType string
+			kind     string
+			urls     mapper.Assets
+		}{
+			{edgeAnchor, nodePage, page.Anchors},
+			{edgeStylesheet, edgeStylesheet, stylesheetLinks},
+			{edgeLink, edgeLink, otherLinks},
+			{edgeScript, edgeScript, page.Scripts},
+			{edgeImage, edgeImage, page.Images},
+			{edgeMedia, edgeMedia, page.Media},
+			{edgeIframe, nodePage, page.Iframes},
+			{edgeObject, edgeObject, page.Objects},
+			{edgeForm, edgeForm, page.Forms},
+			{edgeCSS, edgeCSS, page.CSSAssets},
+		} {
+			for _, url := range group.urls {
+				edges = append(edges, graphEdge{Source: page.URL, Target: url, Type: group.edgeType})
+
+				if _, ok := others[url]; !ok && !crawled[url] {
+					others[url] = group.kind
+				}
+			}
+		}
+	}
+
+	var urls []string
+	for url := range others {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	for _, url := range urls {
+		nodes = append(nodes, graphNode{ID: url, Kind: others[url]})
+	}
+
+	return nodes, edges
+}
+
+// the GraphML document structure.
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// GraphML renders our results in GraphML format (e.g. for use with Cytoscape
+// or Gephi), including each node's attributes and each edge's type.
+func GraphML(results []mapper.Page) string {
+	nodes, edges := graph(results)
+
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "kind", For: "node", Name: "kind", Type: "string"},
+			{ID: "status", For: "node", Name: "status", Type: "int"},
+			{ID: "depth", For: "node", Name: "depth", Type: "int"},
+			{ID: "content_type", For: "node", Name: "content_type", Type: "string"},
+			{ID: "size", For: "node", Name: "size", Type: "long"},
+			{ID: "title", For: "node", Name: "title", Type: "string"},
+			{ID: "error", For: "node", Name: "error", Type: "string"},
+			{ID: "type", For: "edge", Name: "type", Type: "string"},
+		},
+		Graph: graphMLGraph{ID: "sitemap", EdgeDefault: "directed"},
+	}
+
+	for _, node := range nodes {
+		n := graphMLNode{ID: node.ID}
+		for _, attr := range nodeAttributes(node) {
+			n.Data = append(n.Data, graphMLData{Key: attr[0], Value: attr[1]})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+
+	for i, edge := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     fmt.Sprintf("e%d", i),
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   []graphMLData{{Key: "type", Value: edge.Type}},
+		})
+	}
+
+	return marshalXML(doc)
+}
+
+// the GEXF document structure.
+type gexf struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// GEXF renders our results in GEXF format (the native format of Gephi),
+// including each node's attributes and each edge's type.
+func GEXF(results []mapper.Page) string {
+	nodes, edges := graph(results)
+
+	doc := gexf{
+		XMLNS:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes: []gexfAttributes{
+				{Class: "node", Attributes: []gexfAttribute{
+					{ID: "kind", Title: "kind", Type: "string"},
+					{ID: "status", Title: "status", Type: "integer"},
+					{ID: "depth", Title: "depth", Type: "integer"},
+					{ID: "content_type", Title: "content_type", Type: "string"},
+					{ID: "size", Title: "size", Type: "long"},
+					{ID: "title", Title: "title", Type: "string"},
+					{ID: "error", Title: "error", Type: "string"},
+				}},
+				{Class: "edge", Attributes: []gexfAttribute{
+					{ID: "type", Title: "type", Type: "string"},
+				}},
+			},
+		},
+	}
+
+	for _, node := range nodes {
+		// the title makes for a friendlier label, but not every page has one
+		label := node.Title
+		if label == "" {
+			label = node.ID
+		}
+
+		n := gexfNode{ID: node.ID, Label: label}
+		for _, attr := range nodeAttributes(node) {
+			n.AttValues = append(n.AttValues, gexfAttValue{For: attr[0], Value: attr[1]})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+
+	for i, edge := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:        fmt.Sprintf("e%d", i),
+			Source:    edge.Source,
+			Target:    edge.Target,
+			Label:     edge.Type,
+			AttValues: []gexfAttValue{{For: "type", Value: edge.Type}},
+		})
+	}
+
+	return marshalXML(doc)
+}
+
+// nodeAttributes returns the (key, value) attributes of a node, leaving out
+// those that don't apply (e.g. a stylesheet has no depth).
+func nodeAttributes(node graphNode) [][2]string {
+	attrs := [][2]string{{"kind", node.Kind}}
+
+	if node.Crawled {
+		if node.Status != 0 {
+			attrs = append(attrs, [2]string{"status", strconv.Itoa(node.Status)})
+		}
+		attrs = append(attrs, [2]string{"depth", strconv.Itoa(node.Depth)})
+		if node.Size > 0 {
+			attrs = append(attrs, [2]string{"size", strconv.FormatInt(node.Size, 10)})
+		}
+	}
+
+	for _, attr := range [][2]string{
+		{"content_type", node.ContentType},
+		{"title", node.Title},
+		{"error", node.Error},
+	} {
+		if attr[1] != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
+
+// marshalXML renders a document with the XML declaration.
+func marshalXML(v interface{}) string {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return xml.Header + string(b)
+}
+
+// CSV writes the link graph as a nodes.csv and an edges.csv file (suitable for
+// a spreadsheet, or for importing into Gephi/Cytoscape), returning the paths
+// of the files written.
+func CSV(results []mapper.Page, dir string) ([]string, error) {
+	nodes, edges := graph(results)
+
+	nodeRows := [][]string{{"id", "kind", "status", "depth", "content_type", "size", "title", "error"}}
+	for _, node := range nodes {
+		var status, depth, size string
+		if node.Crawled {
+			if node.Status != 0 {
+				status = strconv.Itoa(node.Status)
+			}
+			depth = strconv.Itoa(node.Depth)
+			if node.Size > 0 {
+				size = strconv.FormatInt(node.Size, 10)
+			}
+		}
+		nodeRows = append(nodeRows, []string{node.ID, node.Kind, status, depth, node.ContentType, size, node.Title, node.Error})
+	}
+
+	edgeRows := [][]string{{"source", "target", "type"}}
+	for _, edge := range edges {
+		edgeRows = append(edgeRows, []string{edge.Source, edge.Target, edge.Type})
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, file := range []struct {
+		name string
+		rows [][]string
+	}{
+		{"nodes.csv", nodeRows},
+		{"edges.csv", edgeRows},
+	} {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.WriteAll(file.rows); err != nil {
+			return files, err
+		}
+
+		path := filepath.Join(dir, file.name)
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return files, err
+		}
+		files = append(files, path)
+	}
+
+	return files, nil
+}
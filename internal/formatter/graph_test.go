@@ -0,0 +1,147 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+var graphResults = []mapper.Page{
+	{
+		URL:         "http://www.example.com/",
+		Status:      200,
+		ContentType: "text/html",
+		Title:       "Home & <Index>",
+		Anchors:     mapper.Assets{"http://www.example.com/foo", "http://www.example.com/bar"},
+		Links:       mapper.Assets{"http://www.example.com/main.css", "http://www.example.com/favicon.ico"},
+		Scripts:     mapper.Assets{"http://www.example.com/main.js"},
+		Stylesheets: mapper.Assets{"http://www.example.com/main.css"},
+	},
+	{
+		URL:    "http://www.example.com/foo",
+		Status: 404,
+		Depth:  1,
+	},
+}
+
+func TestGraph(t *testing.T) {
+	nodes, edges := graph(graphResults)
+
+	expectedNodes := []graphNode{
+		{ID: "http://www.example.com/", Kind: "page", Crawled: true, Status: 200, ContentType: "text/html", Title: "Home & <Index>"},
+		{ID: "http://www.example.com/foo", Kind: "page", Crawled: true, Status: 404, Depth: 1},
+		{ID: "http://www.example.com/bar", Kind: "page"},
+		{ID: "http://www.example.com/favicon.ico", Kind: "link"},
+		{ID: "http://www.example.com/main.css", Kind: "stylesheet"},
+		{ID: "http://www.example.com/main.js", Kind: "script"},
+	}
+	if len(nodes) != len(expectedNodes) {
+		t.Fatalf("expected: %+v\ngot: %+v", expectedNodes, nodes)
+	}
+	for i, node := range nodes {
+		if node != expectedNodes[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expectedNodes[i], node)
+		}
+	}
+
+	expectedEdges := []graphEdge{
+		{Source: "http://www.example.com/", Target: "http://www.example.com/foo", Type: "anchor"},
+		{Source: "http://www.example.com/", Target: "http://www.example.com/bar", Type: "anchor"},
+		{Source: "http://www.example.com/", Target: "http://www.example.com/main.css", Type: "stylesheet"},
+		{Source: "http://www.example.com/", Target: "http://www.example.com/favicon.ico", Type: "link"},
+		{Source: "http://www.example.com/", Target: "http://www.example.com/main.js", Type: "script"},
+	}
+	if len(edges) != len(expectedEdges) {
+		t.Fatalf("expected: %+v\ngot: %+v", expectedEdges, edges)
+	}
+	for i, edge := range edges {
+		if edge != expectedEdges[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expectedEdges[i], edge)
+		}
+	}
+}
+
+func TestGraphMLAndGEXF(t *testing.T) {
+	for name, output := range map[string]string{
+		"graphml": GraphML(graphResults),
+		"gexf":    GEXF(graphResults),
+	} {
+		// the output must be well formed XML (e.g. the title must be escaped)
+		decoder := xml.NewDecoder(strings.NewReader(output))
+		for {
+			_, err := decoder.Token()
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("%s expected: well formed XML\ngot: %+v", name, err)
+				}
+				break
+			}
+		}
+
+		if count := strings.Count(output, "<edge "); count != 5 {
+			t.Errorf("%s expected: %+v\ngot: %+v", name, 5, count)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := CSV(graphResults, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := []string{filepath.Join(dir, "nodes.csv"), filepath.Join(dir, "edges.csv")}
+	if len(files) != 2 || files[0] != expectedFiles[0] || files[1] != expectedFiles[1] {
+		t.Errorf("expected: %+v\ngot: %+v", expectedFiles, files)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"http://www.example.com/", "page", "200", "0", "text/html", "", "Home & <Index>", ""}
+	if len(rows) != 7 || strings.Join(rows[1], ",") != strings.Join(expected, ",") {
+		t.Errorf("expected: %+v\ngot: %+v", expected, rows)
+	}
+
+	// a node that wasn't crawled has no status or depth
+	expected = []string{"http://www.example.com/bar", "page", "", "", "", "", "", ""}
+	if strings.Join(rows[3], ",") != strings.Join(expected, ",") {
+		t.Errorf("expected: %+v\ngot: %+v", expected, rows[3])
+	}
+}
+
+func TestGraphResource(t *testing.T) {
+	nodes, _ := graph([]mapper.Page{
+		{URL: "http://www.example.com/report.pdf", Status: 200, ContentType: "application/pdf", Size: 2048, Depth: 1},
+	})
+
+	expected := graphNode{ID: "http://www.example.com/report.pdf", Kind: "resource", Crawled: true, Status: 200, Depth: 1, ContentType: "application/pdf", Size: 2048}
+	if len(nodes) != 1 || nodes[0] != expected {
+		t.Errorf("expected: %+v\ngot: %+v", expected, nodes)
+	}
+
+	if output := GraphML([]mapper.Page{{URL: "http://www.example.com/report.pdf", Status: 200, ContentType: "application/pdf", Size: 2048}}); !strings.Contains(output, `<data key="size">2048</data>`) {
+		t.Errorf("expected: %+v\ngot: %+v", "a size attribute", output)
+	}
+}
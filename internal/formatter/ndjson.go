@@ -0,0 +1,65 @@
+package formatter
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+// NDJSON streams the results as newline delimited JSON, where each page is
+// written as a single line the moment it has been mapped (rather than waiting
+// for the crawl to finish), followed by a final summary line.
+//
+// note: every line is written with a single call to the underlying writer, so
+// the output remains valid (line by line) even if the crawl is interrupted.
+type NDJSON struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// ndjsonSummary is the final line of the output, which is distinguishable from
+// a page by its `Summary` key.
+type ndjsonSummary struct {
+	Summary ndjsonTotals
+}
+
+// ndjsonTotals are the crawl-wide totals included in the summary line.
+type ndjsonTotals struct {
+	Pages    int
+	Duration string
+	Summary
+}
+
+// NewNDJSON returns a NDJSON stream that writes to w.
+func NewNDJSON(w io.Writer) *NDJSON {
+	return &NDJSON{encoder: json.NewEncoder(w)}
+}
+
+// Page writes a single page, and is safe for concurrent use.
+func (n *NDJSON) Page(page mapper.Page) {
+	n.write(page)
+}
+
+// Summary writes the final summary line.
+func (n *NDJSON) Summary(pages int, summary Summary, startTime time.Time) {
+	n.write(ndjsonSummary{
+		Summary: ndjsonTotals{
+			Pages:    pages,
+			Duration: time.Since(startTime).String(),
+			Summary:  summary,
+		},
+	})
+}
+
+func (n *NDJSON) write(v interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// note: Encode appends the newline and writes the line in one go, and the
+	// only way it can fail is if stdout is closed (e.g. the reading end of a pipe
+	// exited) in which case there's nobody left to report the error to.
+	n.encoder.Encode(v)
+}
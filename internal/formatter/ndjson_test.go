@@ -0,0 +1,48 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+func TestNDJSON(t *testing.T) {
+	var output bytes.Buffer
+
+	stream := NewNDJSON(&output)
+	stream.Page(mapper.Page{URL: "http://www.example.com/", Status: 200})
+	stream.Page(mapper.Page{URL: "http://www.example.com/foo", Status: 404})
+	stream.Summary(2, Summary{StopReason: "interrupted"}, time.Now())
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected: %+v\ngot: %+v", 3, len(lines))
+	}
+
+	for i, url := range []string{"http://www.example.com/", "http://www.example.com/foo"} {
+		var page mapper.Page
+		if err := json.Unmarshal([]byte(lines[i]), &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.URL != url {
+			t.Errorf("expected: %+v\ngot: %+v", url, page.URL)
+		}
+	}
+
+	var summary struct {
+		Summary struct {
+			Pages      int
+			StopReason string
+		}
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Summary.Pages != 2 || summary.Summary.StopReason != "interrupted" {
+		t.Errorf("expected: %+v\ngot: %+v", "2 pages (interrupted)", summary.Summary)
+	}
+}
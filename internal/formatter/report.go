@@ -0,0 +1,102 @@
+package formatter
+
+import (
+	_ "embed" // the report's template, stylesheet and script are embedded
+	"html/template"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+// the report is a single HTML file with its stylesheet and script inlined, so
+// that it can be opened from the file system (or attached to a ticket) without
+// needing access to the network.
+var (
+	//go:embed report/report.html
+	reportHTML string
+
+	//go:embed report/report.css
+	reportCSS string
+
+	//go:embed report/report.js
+	reportJS string
+)
+
+// reportPage is a crawled page along with the crawled pages that link to it
+// (which is far cheaper to work out here than in the browser).
+type reportPage struct {
+	mapper.Page
+	Inbound []string `json:",omitempty"`
+}
+
+// reportData is the data rendered into the report template.
+type reportData struct {
+	Host string
+	CSS  template.CSS
+	JS   template.JS
+	Data struct {
+		Pages   []reportPage
+		Summary Summary
+	}
+}
+
+// Report writes the results as an interactive HTML report to the given path.
+//
+// note: html/template takes care of encoding the results as JSON within the
+// script element, so a URL containing markup can't break out of it.
+func Report(results []mapper.Page, summary Summary, path string) error {
+	tmpl, err := template.New("report").Parse(reportHTML)
+	if err != nil {
+		return err
+	}
+
+	data := reportData{
+		CSS: template.CSS(reportCSS),
+		JS:  template.JS(reportJS),
+	}
+	data.Data.Pages = reportPages(results)
+	data.Data.Summary = summary
+
+	if len(results) > 0 {
+		if u, err := url.Parse(results[0].URL); err == nil {
+			data.Host = u.Host
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := tmpl.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// reportPages works out the inbound links for every crawled page.
+func reportPages(results []mapper.Page) []reportPage {
+	inbound := map[string][]string{}
+	for _, page := range results {
+		seen := map[string]bool{}
+		for _, anchor := range page.Anchors {
+			if anchor == page.URL || seen[anchor] {
+				continue
+			}
+			seen[anchor] = true
+			inbound[anchor] = append(inbound[anchor], page.URL)
+		}
+	}
+
+	pages := make([]reportPage, len(results))
+	for i, page := range results {
+		sort.Strings(inbound[page.URL])
+		pages[i] = reportPage{Page: page, Inbound: inbound[page.URL]}
+	}
+
+	return pages
+}
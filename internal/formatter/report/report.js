@@ -0,0 +1,496 @@
+// report.js renders the crawl results (window.REPORT) as an interactive graph.
+//
+// note: this file is embedded into a single HTML file, so it mustn't depend on
+// anything that isn't available offline (i.e. no modules, fetch or CDNs).
+(function () {
+  "use strict";
+
+  var report = window.REPORT;
+  var canvas = document.getElementById("graph");
+  var context = canvas.getContext("2d");
+  var panel = document.getElementById("panel");
+  var search = document.getElementById("search");
+  var prefix = document.getElementById("prefix");
+  var layout = document.getElementById("layout");
+  var stats = document.getElementById("stats");
+
+  var colours = {
+    ok: "#28a745",
+    redirect: "#f66a0a",
+    broken: "#d73a49",
+    blocked: "#959da5"
+  };
+
+  // nodes are the crawled pages, and edges are the anchors between them.
+  var nodes = [];
+  var byURL = {};
+  report.Pages.forEach(function (page) {
+    var node = {
+      page: page,
+      path: pathOf(page.URL),
+      x: 0,
+      y: 0,
+      vx: 0,
+      vy: 0,
+      visible: true,
+      match: false
+    };
+    nodes.push(node);
+    byURL[page.URL] = node;
+  });
+
+  var edges = [];
+  nodes.forEach(function (node) {
+    (node.page.Anchors || []).forEach(function (url) {
+      var target = byURL[url];
+      if (target && target !== node) {
+        edges.push({ source: node, target: target });
+      }
+    });
+  });
+
+  var selected = null;
+  var view = { x: 0, y: 0, scale: 1 };
+  var alpha = 1;
+
+  function pathOf(url) {
+    var a = document.createElement("a");
+    a.href = url;
+    return a.pathname || "/";
+  }
+
+  function category(page) {
+    if (page.Blocked) {
+      return "blocked";
+    }
+    if (page.Error || page.RedirectLoop || (page.Status && page.Status !== 200)) {
+      return "broken";
+    }
+    if (page.Redirects && page.Redirects.length > 0) {
+      return "redirect";
+    }
+    return "ok";
+  }
+
+  // positions
+
+  function hierarchy() {
+    var columns = {};
+    nodes.forEach(function (node) {
+      var depth = node.page.Depth || 0;
+      (columns[depth] = columns[depth] || []).push(node);
+    });
+    Object.keys(columns).forEach(function (depth) {
+      var column = columns[depth];
+      column.sort(function (a, b) {
+        return a.path < b.path ? -1 : 1;
+      });
+      column.forEach(function (node, i) {
+        node.x = depth * 220;
+        node.y = (i - column.length / 2) * 24;
+        node.vx = node.vy = 0;
+      });
+    });
+    alpha = 0;
+  }
+
+  function scatter() {
+    nodes.forEach(function (node, i) {
+      var angle = i * 2.399963; // the golden angle spreads the nodes evenly
+      var radius = 10 * Math.sqrt(i);
+      node.x = radius * Math.cos(angle);
+      node.y = radius * Math.sin(angle);
+      node.vx = node.vy = 0;
+    });
+    alpha = 1;
+  }
+
+  // tick advances the force-directed layout by a single step.
+  //
+  // note: repulsion only considers nearby nodes (bucketed into a grid) so that
+  // large sites don't grind to a halt.
+  function tick() {
+    var visible = nodes.filter(function (node) {
+      return node.visible;
+    });
+    var size = 120;
+    var grid = {};
+    visible.forEach(function (node) {
+      var key = Math.floor(node.x / size) + "," + Math.floor(node.y / size);
+      (grid[key] = grid[key] || []).push(node);
+    });
+
+    visible.forEach(function (node) {
+      var gx = Math.floor(node.x / size);
+      var gy = Math.floor(node.y / size);
+      for (var i = -1; i <= 1; i++) {
+        for (var j = -1; j <= 1; j++) {
+          (grid[(gx + i) + "," + (gy + j)] || []).forEach(function (other) {
+            if (other === node) {
+              return;
+            }
+            var dx = node.x - other.x;
+            var dy = node.y - other.y;
+            var distance = dx * dx + dy * dy || 0.01;
+            var force = (400 / distance) * alpha;
+            node.vx += dx * force;
+            node.vy += dy * force;
+          });
+        }
+      }
+    });
+
+    edges.forEach(function (edge) {
+      if (!edge.source.visible || !edge.target.visible) {
+        return;
+      }
+      var dx = edge.target.x - edge.source.x;
+      var dy = edge.target.y - edge.source.y;
+      var force = 0.01 * alpha;
+      edge.source.vx += dx * force;
+      edge.source.vy += dy * force;
+      edge.target.vx -= dx * force;
+      edge.target.vy -= dy * force;
+    });
+
+    visible.forEach(function (node) {
+      node.vx -= node.x * 0.002 * alpha;
+      node.vy -= node.y * 0.002 * alpha;
+      node.x += node.vx;
+      node.y += node.vy;
+      node.vx *= 0.6;
+      node.vy *= 0.6;
+    });
+
+    alpha *= 0.98;
+  }
+
+  // drawing
+
+  function resize() {
+    canvas.width = canvas.clientWidth * window.devicePixelRatio;
+    canvas.height = canvas.clientHeight * window.devicePixelRatio;
+  }
+
+  function draw() {
+    var ratio = window.devicePixelRatio;
+    context.setTransform(1, 0, 0, 1, 0, 0);
+    context.clearRect(0, 0, canvas.width, canvas.height);
+    context.setTransform(
+      view.scale * ratio, 0, 0, view.scale * ratio,
+      (canvas.clientWidth / 2 + view.x) * ratio,
+      (canvas.clientHeight / 2 + view.y) * ratio
+    );
+
+    context.lineWidth = 0.5 / view.scale;
+    edges.forEach(function (edge) {
+      if (!edge.source.visible || !edge.target.visible) {
+        return;
+      }
+      var highlighted = selected && (edge.source === selected || edge.target === selected);
+      context.strokeStyle = highlighted ? "rgba(3, 102, 214, 0.8)" : "rgba(149, 157, 165, 0.25)";
+      context.beginPath();
+      context.moveTo(edge.source.x, edge.source.y);
+      context.lineTo(edge.target.x, edge.target.y);
+      context.stroke();
+    });
+
+    nodes.forEach(function (node) {
+      if (!node.visible) {
+        return;
+      }
+      var radius = node === selected ? 7 : 4;
+      context.fillStyle = colours[category(node.page)];
+      context.beginPath();
+      context.arc(node.x, node.y, radius / Math.sqrt(view.scale), 0, Math.PI * 2);
+      context.fill();
+
+      if (node.match || node === selected) {
+        context.strokeStyle = "#0366d6";
+        context.lineWidth = 2 / view.scale;
+        context.stroke();
+        context.fillStyle = "#24292e";
+        context.font = 12 / view.scale + "px sans-serif";
+        context.fillText(node.path, node.x + 8 / view.scale, node.y + 4 / view.scale);
+        context.lineWidth = 0.5 / view.scale;
+      }
+    });
+  }
+
+  function frame() {
+    if (alpha > 0.01) {
+      tick();
+    }
+    draw();
+    window.requestAnimationFrame(frame);
+  }
+
+  // filtering and searching
+
+  function filter() {
+    var value = prefix.value.trim();
+    var term = search.value.trim().toLowerCase();
+    var shown = 0;
+
+    nodes.forEach(function (node) {
+      node.visible = value === "" || node.path.indexOf(value) === 0;
+      node.match = term !== "" && node.visible && node.page.URL.toLowerCase().indexOf(term) !== -1;
+      if (node.visible) {
+        shown++;
+      }
+    });
+
+    stats.textContent = shown + " of " + nodes.length + " pages";
+    if (layout.value === "force") {
+      alpha = Math.max(alpha, 0.3);
+    }
+
+    if (!selected) {
+      overview();
+    }
+  }
+
+  // panels
+
+  function element(tag, text, attributes) {
+    var el = document.createElement(tag);
+    if (text !== undefined) {
+      el.textContent = text;
+    }
+    Object.keys(attributes || {}).forEach(function (key) {
+      el.setAttribute(key, attributes[key]);
+    });
+    return el;
+  }
+
+  // list renders a titled list of URLs, where crawled pages can be selected.
+  function list(title, urls) {
+    panel.appendChild(element("h3", title + " (" + urls.length + ")"));
+    var ul = element("ul");
+    urls.forEach(function (url) {
+      var li = element("li");
+      if (byURL[url]) {
+        var a = element("a", url);
+        a.addEventListener("click", function () {
+          select(byURL[url]);
+        });
+        li.appendChild(a);
+      } else {
+        li.textContent = url;
+      }
+      ul.appendChild(li);
+    });
+    panel.appendChild(ul);
+  }
+
+  function select(node) {
+    selected = node;
+    if (!node) {
+      overview();
+      return;
+    }
+
+    var page = node.page;
+    panel.textContent = "";
+
+    var back = element("a", "← all pages");
+    back.addEventListener("click", function () {
+      select(null);
+    });
+    panel.appendChild(back);
+
+    panel.appendChild(element("h2", page.URL));
+
+    var status = element("span", page.Blocked ? "blocked" : page.Error ? "error" : String(page.Status || 200), { class: "status" });
+    status.style.background = colours[category(page)];
+    panel.appendChild(status);
+    panel.appendChild(document.createTextNode(" depth " + (page.Depth || 0)));
+    if (page.ContentType) {
+      panel.appendChild(document.createTextNode(" · " + page.ContentType + (page.Size ? " (" + page.Size + " bytes)" : "")));
+    }
+
+    if (page.Error) {
+      panel.appendChild(element("p", page.Error));
+    }
+
+    if (page.Title) {
+      panel.appendChild(element("h3", page.Title));
+    }
+    if (page.Description) {
+      panel.appendChild(element("p", page.Description));
+    }
+    var about = [];
+    if (page.Lang) {
+      about.push("lang " + page.Lang);
+    }
+    if (page.WordCount) {
+      about.push(page.WordCount + " words");
+    }
+    if (page.MetaRobots) {
+      about.push("robots " + page.MetaRobots);
+    }
+    if (page.XRobotsTag) {
+      about.push("X-Robots-Tag " + page.XRobotsTag);
+    }
+    if (page.NoIndex) {
+      about.push("noindex");
+    }
+    if (page.NoFollow) {
+      about.push("nofollow");
+    }
+    if (about.length > 0) {
+      panel.appendChild(element("p", about.join(" · ")));
+    }
+
+    if (page.Headings && page.Headings.length > 0) {
+      list("Headings", page.Headings.map(function (heading) {
+        return "h" + heading.Level + " " + heading.Text;
+      }));
+    }
+    if (page.Alternates && page.Alternates.length > 0) {
+      list("Alternates", page.Alternates.map(function (alternate) {
+        return alternate.Lang + " " + alternate.URL;
+      }));
+    }
+
+    if (page.Redirects && page.Redirects.length > 0) {
+      list("Redirects", page.Redirects.map(function (hop) {
+        return hop.URL + " (" + hop.Status + ")";
+      }).concat(page.FinalURL ? [page.FinalURL] : []));
+    }
+
+    list("Inbound links", page.Inbound || []);
+    list("Outbound links", page.Anchors || []);
+    list("Nofollow links", page.NoFollowURLs || []);
+    // a <link> is only a stylesheet when it's listed as one (it can also be an
+    // icon, a preconnect hint and so on).
+    var stylesheets = page.Stylesheets || [];
+    list("Stylesheets", (page.Links || []).filter(function (url) {
+      return stylesheets.indexOf(url) >= 0;
+    }));
+    list("Other links", (page.Links || []).filter(function (url) {
+      return stylesheets.indexOf(url) < 0;
+    }));
+    list("Scripts", page.Scripts || []);
+    list("Images", page.Images || []);
+    list("Media", page.Media || []);
+    list("Iframes", page.Iframes || []);
+    list("Objects", page.Objects || []);
+    list("Forms", page.Forms || []);
+    list("CSS assets", page.CSSAssets || []);
+
+    // bring the selected page into view
+    view.x = -node.x * view.scale;
+    view.y = -node.y * view.scale;
+  }
+
+  function overview() {
+    panel.textContent = "";
+    panel.appendChild(element("h2", "Pages"));
+
+    var legend = element("div", undefined, { class: "legend" });
+    Object.keys(colours).forEach(function (name) {
+      var swatch = element("span");
+      swatch.style.background = colours[name];
+      legend.appendChild(swatch);
+      legend.appendChild(document.createTextNode(name));
+    });
+    panel.appendChild(legend);
+
+    var term = search.value.trim();
+    var matches = nodes.filter(function (node) {
+      return node.visible && (term === "" || node.match);
+    });
+
+    list(term === "" ? "All pages" : "Matching pages", matches.map(function (node) {
+      return node.page.URL;
+    }).sort());
+  }
+
+  // interaction
+
+  function nodeAt(x, y) {
+    var point = {
+      x: (x - canvas.clientWidth / 2 - view.x) / view.scale,
+      y: (y - canvas.clientHeight / 2 - view.y) / view.scale
+    };
+    var closest = null;
+    var best = 100 / (view.scale * view.scale);
+    nodes.forEach(function (node) {
+      if (!node.visible) {
+        return;
+      }
+      var distance = Math.pow(node.x - point.x, 2) + Math.pow(node.y - point.y, 2);
+      if (distance < best) {
+        best = distance;
+        closest = node;
+      }
+    });
+    return closest;
+  }
+
+  var drag = null;
+
+  canvas.addEventListener("mousedown", function (event) {
+    drag = { x: event.clientX, y: event.clientY, moved: false };
+  });
+
+  window.addEventListener("mousemove", function (event) {
+    if (!drag) {
+      return;
+    }
+    view.x += event.clientX - drag.x;
+    view.y += event.clientY - drag.y;
+    drag.moved = drag.moved || Math.abs(event.clientX - drag.x) + Math.abs(event.clientY - drag.y) > 2;
+    drag.x = event.clientX;
+    drag.y = event.clientY;
+  });
+
+  window.addEventListener("mouseup", function (event) {
+    if (drag && !drag.moved) {
+      var rect = canvas.getBoundingClientRect();
+      var node = nodeAt(event.clientX - rect.left, event.clientY - rect.top);
+      if (node) {
+        select(node);
+      }
+    }
+    drag = null;
+  });
+
+  canvas.addEventListener("wheel", function (event) {
+    event.preventDefault();
+    var factor = event.deltaY < 0 ? 1.1 : 1 / 1.1;
+    view.scale *= factor;
+    view.x *= factor;
+    view.y *= factor;
+  });
+
+  search.addEventListener("input", filter);
+  search.addEventListener("keydown", function (event) {
+    if (event.key !== "Enter") {
+      return;
+    }
+    var match = nodes.filter(function (node) {
+      return node.match;
+    })[0];
+    if (match) {
+      select(match);
+    }
+  });
+  prefix.addEventListener("input", filter);
+
+  layout.addEventListener("change", function () {
+    if (layout.value === "hierarchy") {
+      hierarchy();
+    } else {
+      scatter();
+    }
+  });
+
+  window.addEventListener("resize", resize);
+
+  resize();
+  scatter();
+  filter();
+  frame();
+})();
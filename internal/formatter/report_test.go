@@ -0,0 +1,72 @@
+package formatter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+func TestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	results := []mapper.Page{
+		{URL: "http://www.example.com/", Anchors: mapper.Assets{"http://www.example.com/foo", "http://www.example.com/"}},
+		{URL: "http://www.example.com/foo", Anchors: mapper.Assets{"http://www.example.com/</script><script>alert(1)</script>"}},
+	}
+
+	path := filepath.Join(dir, "report.html")
+	if err := Report(results, Summary{}, path); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := string(b)
+
+	for _, expected := range []string{"<title>Site map: www.example.com</title>", "window.REPORT", "report.js renders"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected: %+v\ngot: %+v", expected, "no match")
+		}
+	}
+
+	// the report must not reference anything that isn't embedded
+	for _, unexpected := range []string{"<script src", "<link rel", "alert(1)</script>"} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("unexpected: %+v", unexpected)
+		}
+	}
+}
+
+func TestReportPages(t *testing.T) {
+	results := []mapper.Page{
+		{URL: "http://www.example.com/", Anchors: mapper.Assets{"http://www.example.com/foo", "http://www.example.com/"}},
+		{URL: "http://www.example.com/bar", Anchors: mapper.Assets{"http://www.example.com/foo", "http://www.example.com/foo"}},
+		{URL: "http://www.example.com/foo"},
+	}
+
+	pages := reportPages(results)
+
+	if len(pages[0].Inbound) != 0 {
+		t.Errorf("expected: %+v\ngot: %+v", []string{}, pages[0].Inbound)
+	}
+
+	expected := []string{"http://www.example.com/", "http://www.example.com/bar"}
+	if len(pages[2].Inbound) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, pages[2].Inbound)
+	}
+	for i, url := range pages[2].Inbound {
+		if url != expected[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], url)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package formatter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/requester"
+)
+
+// the limits for a single sitemap file (as per sitemaps.org), which are
+// variables rather than constants so the tests can lower them.
+//
+// note: the size limit applies to the uncompressed file.
+var (
+	sitemapMaxURLs  = 50000
+	sitemapMaxBytes = 50 * 1024 * 1024
+)
+
+const (
+	sitemapHeader = xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	sitemapFooter = "</urlset>\n"
+	indexHeader   = xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	indexFooter   = "</sitemapindex>\n"
+)
+
+// SitemapConfig describes where the sitemap files are written.
+//
+// BaseURL is the URL the files will be served from, which is needed because a
+// sitemap index has to reference each sitemap using an absolute URL.
+type SitemapConfig struct {
+	Dir     string
+	BaseURL string
+	Gzip    bool
+}
+
+// sitemapURL is a single <url> entry within a sitemap.
+type sitemapURL struct {
+	XMLName xml.Name `xml:"url"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+}
+
+// sitemapRef is a single <sitemap> entry within a sitemap index.
+type sitemapRef struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Loc     string   `xml:"loc"`
+}
+
+// Sitemap writes the crawled pages as a sitemaps.org XML file, returning the
+// paths of the files that were written.
+//
+// Only pages that responded with a 200, that haven't asked not to be indexed
+// and that are canonical (i.e. don't specify a different canonical URL) are
+// included. When there are too many pages for a single file they're split over
+// multiple files, and a sitemap index referencing each of them is written to
+// sitemap.xml instead.
+func Sitemap(results []mapper.Page, config SitemapConfig) ([]string, error) {
+	var entries [][]byte
+
+	for _, page := range sitemapPages(results) {
+		entry, err := xml.MarshalIndent(sitemapURL{Loc: sitemapLoc(page), LastMod: page.LastModified}, "  ", "  ")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, append(entry, '\n'))
+	}
+
+	files := splitSitemap(entries)
+
+	ext := ".xml"
+	if config.Gzip {
+		ext += ".gz"
+	}
+
+	if len(files) == 1 {
+		path := filepath.Join(config.Dir, "sitemap"+ext)
+		return []string{path}, writeSitemap(path, files[0], config.Gzip)
+	}
+
+	var written []string
+	var refs [][]byte
+
+	for i, file := range files {
+		name := fmt.Sprintf("sitemap-%d%s", i+1, ext)
+		path := filepath.Join(config.Dir, name)
+		if err := writeSitemap(path, file, config.Gzip); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+
+		ref, err := xml.MarshalIndent(sitemapRef{Loc: strings.TrimSuffix(config.BaseURL, "/") + "/" + name}, "  ", "  ")
+		if err != nil {
+			return written, err
+		}
+		refs = append(refs, append(ref, '\n'))
+	}
+
+	var index bytes.Buffer
+	index.WriteString(indexHeader)
+	for _, ref := range refs {
+		index.Write(ref)
+	}
+	index.WriteString(indexFooter)
+
+	path := filepath.Join(config.Dir, "sitemap"+ext)
+	if err := writeSitemap(path, index.Bytes(), config.Gzip); err != nil {
+		return written, err
+	}
+
+	return append([]string{path}, written...), nil
+}
+
+// sitemapPages filters the results down to the pages that belong in a sitemap,
+// sorted by URL so the output is deterministic.
+func sitemapPages(results []mapper.Page) []mapper.Page {
+	var pages []mapper.Page
+
+	for _, page := range results {
+		if page.Error != "" || page.Status != 200 || page.NoIndex {
+			continue
+		}
+
+		// a URL that redirected to a page we crawled separately isn't a page.
+		if page.FinalURL != "" {
+			continue
+		}
+
+		// a resource that was linked to (e.g. an image or a PDF) isn't a page.
+		if !requester.IsHTML(page.ContentType) {
+			continue
+		}
+
+		// a page that specifies a different canonical URL is a duplicate, and it's
+		// the canonical URL that should be indexed instead.
+		if page.Canonical != "" && page.Canonical != page.URL {
+			continue
+		}
+
+		pages = append(pages, page)
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+
+	return pages
+}
+
+// sitemapLoc returns the URL a page was actually served from, which is the URL
+// it was requested with unless it was redirected.
+//
+// note: the URL of a redirected page is the normalized form of the URL it was
+// redirected to (e.g. with its query parameters sorted, or its trailing slash
+// stripped when using `-trailing-slash strip`), which isn't necessarily a URL the
+// server will respond to without redirecting again. And so we use the Location
+// of the last redirect instead, as a sitemap shouldn't reference URLs that
+// redirect.
+func sitemapLoc(page mapper.Page) string {
+	if n := len(page.Redirects); n > 0 {
+		return location(page.Redirects[n-1])
+	}
+	return page.URL
+}
+
+// splitSitemap groups the <url> entries into files, starting a new file once
+// either the URL or size limit would be exceeded.
+func splitSitemap(entries [][]byte) [][]byte {
+	var files [][]byte
+	var file bytes.Buffer
+	var count int
+
+	for _, entry := range entries {
+		if count > 0 && (count == sitemapMaxURLs || file.Len()+len(entry)+len(sitemapFooter) > sitemapMaxBytes) {
+			file.WriteString(sitemapFooter)
+			files = append(files, append([]byte(nil), file.Bytes()...))
+			file.Reset()
+			count = 0
+		}
+
+		if count == 0 {
+			file.WriteString(sitemapHeader)
+		}
+
+		file.Write(entry)
+		count++
+	}
+
+	// an empty sitemap is still valid, and is better than no file at all
+	if count == 0 {
+		file.WriteString(sitemapHeader)
+	}
+	file.WriteString(sitemapFooter)
+
+	return append(files, file.Bytes())
+}
+
+// writeSitemap writes a single file, gzip compressing it if requested.
+func writeSitemap(path string, data []byte, compress bool) error {
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,124 @@
+package formatter
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+func TestSitemap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := []mapper.Page{
+		{URL: "http://www.example.com/", Status: 200, LastModified: "2020-01-02T03:04:05Z"},
+		{URL: "http://www.example.com/a?b=1&c=2", Status: 200},
+		{URL: "http://www.example.com/missing", Status: 404},
+		{URL: "http://www.example.com/failed", Error: "timeout"},
+		{URL: "http://www.example.com/private", Status: 200, NoIndex: true},
+		{URL: "http://www.example.com/copy", Status: 200, Canonical: "http://www.example.com/"},
+		{URL: "http://www.example.com/self", Status: 200, Canonical: "http://www.example.com/self"},
+	}
+
+	files, err := Sitemap(input, SitemapConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0] != filepath.Join(dir, "sitemap.xml") {
+		t.Fatalf("expected: %+v\ngot: %+v", filepath.Join(dir, "sitemap.xml"), files)
+	}
+
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>http://www.example.com/</loc>
+    <lastmod>2020-01-02T03:04:05Z</lastmod>
+  </url>
+  <url>
+    <loc>http://www.example.com/a?b=1&amp;c=2</loc>
+  </url>
+  <url>
+    <loc>http://www.example.com/self</loc>
+  </url>
+</urlset>
+`
+
+	if actual := string(b); actual != output {
+		t.Errorf("expected: %s\ngot: %s", output, actual)
+	}
+}
+
+func TestSitemapSplit(t *testing.T) {
+	defer func(n int) { sitemapMaxURLs = n }(sitemapMaxURLs)
+	sitemapMaxURLs = 2
+
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := []mapper.Page{
+		{URL: "http://www.example.com/a", Status: 200},
+		{URL: "http://www.example.com/b", Status: 200},
+		{URL: "http://www.example.com/c", Status: 200},
+	}
+
+	files, err := Sitemap(input, SitemapConfig{Dir: dir, BaseURL: "http://www.example.com/", Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "sitemap.xml.gz"),
+		filepath.Join(dir, "sitemap-1.xml.gz"),
+		filepath.Join(dir, "sitemap-2.xml.gz"),
+	}
+
+	if len(files) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, files)
+	}
+
+	for i, file := range files {
+		if file != expected[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], file)
+		}
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := string(b)
+	for _, loc := range []string{"<loc>http://www.example.com/sitemap-1.xml.gz</loc>", "<loc>http://www.example.com/sitemap-2.xml.gz</loc>"} {
+		if !strings.Contains(index, loc) {
+			t.Errorf("expected: %s\ngot: %s", loc, index)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package frontier
+
+// The frontier package provides the single work queue of URLs that are waiting
+// to be crawled. Workers pop a URL, process it, push any newly discovered URLs
+// and then mark the popped URL as done.
+//
+// The queue knows the crawl has finished when it is empty and there are no
+// URLs still being processed (as processing a URL could push more work).
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Order determines which queued URL is handed out next.
+type Order string
+
+const (
+	// BFS crawls URLs in the order they were discovered (breadth-first).
+	BFS Order = "bfs"
+
+	// DFS crawls the most recently discovered URL first (depth-first).
+	DFS Order = "dfs"
+)
+
+// ParseOrder validates a user provided crawl order.
+func ParseOrder(s string) (Order, error) {
+	switch o := Order(s); o {
+	case BFS, DFS:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown crawl order %q (expected %q or %q)", s, BFS, DFS)
+}
+
+// Item is a URL waiting to be crawled, along with its click depth from the
+// entry page.
+type Item struct {
+	URL   string
+	Depth int
+}
+
+// Queue is a concurrency safe frontier of URLs.
+type Queue struct {
+	order   Order
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	items   []Item
+	pending int
+	stopped bool
+}
+
+// New returns an empty Queue.
+func New(order Order) *Queue {
+	q := &Queue{order: order}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Push adds an item to the queue.
+func (q *Queue) Push(item Item) {
+	q.mutex.Lock()
+	if q.stopped {
+		q.mutex.Unlock()
+		return
+	}
+	q.items = append(q.items, item)
+	q.pending++
+	q.mutex.Unlock()
+
+	q.cond.Signal()
+}
+
+// Pop blocks until an item is available. It returns false once the queue is
+// drained and no other worker is still processing an item (or once the queue
+// has been stopped).
+func (q *Queue) Pop() (Item, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for !q.stopped && len(q.items) == 0 && q.pending > 0 {
+		q.cond.Wait()
+	}
+
+	if q.stopped || len(q.items) == 0 {
+		return Item{}, false
+	}
+
+	var item Item
+	if q.order == DFS {
+		item = q.items[len(q.items)-1]
+		q.items = q.items[:len(q.items)-1]
+	} else {
+		item = q.items[0]
+		q.items = q.items[1:]
+	}
+
+	return item, true
+}
+
+// Done marks a popped item as processed. It must be called once for every item
+// returned by Pop, after any URLs discovered while processing it were pushed.
+func (q *Queue) Done() {
+	q.mutex.Lock()
+	q.pending--
+	drained := q.pending == 0
+	q.mutex.Unlock()
+
+	// wake every blocked worker so they can see there's nothing left to do
+	if drained {
+		q.cond.Broadcast()
+	}
+}
+
+// Stop discards any queued items, causing every subsequent call to Pop to
+// return false (items that have already been popped can still be processed).
+func (q *Queue) Stop() {
+	q.mutex.Lock()
+	q.stopped = true
+	q.pending -= len(q.items)
+	q.items = nil
+	q.mutex.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// Len returns the number of items waiting to be popped.
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.items)
+}
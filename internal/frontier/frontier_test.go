@@ -0,0 +1,98 @@
+package frontier
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestOrder(t *testing.T) {
+	for order, expected := range map[Order][]string{
+		BFS: {"a", "b", "c"},
+		DFS: {"c", "b", "a"},
+	} {
+		q := New(order)
+		for _, url := range []string{"a", "b", "c"} {
+			q.Push(Item{URL: url})
+		}
+
+		for _, url := range expected {
+			item, ok := q.Pop()
+			if !ok || item.URL != url {
+				t.Errorf("%s expected: %+v\ngot: %+v", order, url, item.URL)
+			}
+			q.Done()
+		}
+
+		if _, ok := q.Pop(); ok {
+			t.Errorf("%s expected the queue to be drained", order)
+		}
+	}
+}
+
+func TestTermination(t *testing.T) {
+	q := New(BFS)
+	q.Push(Item{URL: "/"})
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var processed int
+
+	// every item at depth < 3 discovers two more items, so we expect a full
+	// binary tree of 1 + 2 + 4 + 8 items to be processed.
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				item, ok := q.Pop()
+				if !ok {
+					return
+				}
+
+				if item.Depth < 3 {
+					for j := 0; j < 2; j++ {
+						q.Push(Item{URL: fmt.Sprintf("%s%d/", item.URL, j), Depth: item.Depth + 1})
+					}
+				}
+
+				mutex.Lock()
+				processed++
+				mutex.Unlock()
+
+				q.Done()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if processed != 15 {
+		t.Errorf("expected: %+v\ngot: %+v", 15, processed)
+	}
+}
+
+func TestStop(t *testing.T) {
+	q := New(BFS)
+	q.Push(Item{URL: "a"})
+	q.Push(Item{URL: "b"})
+
+	if _, ok := q.Pop(); !ok {
+		t.Fatalf("expected an item to be popped")
+	}
+
+	q.Stop()
+	q.Push(Item{URL: "c"})
+
+	if _, ok := q.Pop(); ok {
+		t.Errorf("expected no items once the queue is stopped")
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("expected: %+v\ngot: %+v", 0, q.Len())
+	}
+
+	q.Done()
+}
@@ -0,0 +1,251 @@
+package limiter
+
+// The limiter package keeps the crawler polite by throttling requests on a
+// per-host basis. Each host gets its own token bucket (so a slow host doesn't
+// hold up requests to a different host) along with a cap on the number of
+// requests that can be in-flight to it at any one time.
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRate is the slowest we'll allow a host to be throttled down to (in
+// requests per second) when it keeps responding with 429 Too Many Requests.
+const minRate = 1.0 / 30
+
+// Config describes how requests to a single host are throttled.
+//
+// A Rate of zero (or less) disables the token bucket, and a MaxInFlight of zero
+// (or less) disables the concurrency cap.
+type Config struct {
+	Rate        float64
+	Burst       int
+	MaxInFlight int
+}
+
+// Limiter throttles requests per host, and is safe for concurrent use.
+type Limiter struct {
+	config Config
+	mutex  sync.Mutex
+	hosts  map[string]*bucket
+}
+
+// bucket tracks the throttling state for a single host.
+type bucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	inFlight chan struct{}
+}
+
+// New returns a Limiter which applies the given configuration to every host.
+func New(c Config) *Limiter {
+	return &Limiter{
+		config: c,
+		hosts:  map[string]*bucket{},
+	}
+}
+
+// bucket returns the bucket for the given host, creating it if necessary.
+//
+// note: the caller must hold the mutex.
+func (l *Limiter) bucket(host string) *bucket {
+	b, ok := l.hosts[host]
+	if ok {
+		return b
+	}
+
+	burst := float64(l.config.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	b = &bucket{
+		rate:   l.config.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+	if l.config.MaxInFlight > 0 {
+		b.inFlight = make(chan struct{}, l.config.MaxInFlight)
+	}
+
+	l.hosts[host] = b
+	return b
+}
+
+// Wait blocks until a request to the given host is permitted (or the context
+// is cancelled). The returned function must be called once the request has
+// completed so that another request to the host can be made.
+func (l *Limiter) Wait(ctx context.Context, host string) (release func(), err error) {
+	l.mutex.Lock()
+	b := l.bucket(host)
+	l.mutex.Unlock()
+
+	// we acquire an in-flight slot before a token, otherwise we'd consume tokens
+	// while blocked and then fire a burst of requests once slots free up.
+	if b.inFlight != nil {
+		select {
+		case b.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if b.inFlight != nil {
+				<-b.inFlight
+			}
+		})
+	}
+
+	for {
+		l.mutex.Lock()
+		if b.rate <= 0 {
+			l.mutex.Unlock()
+			break
+		}
+
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			l.mutex.Unlock()
+			break
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		l.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+// SetCrawlDelay ensures requests to the given host are spaced out by at least
+// the given delay (as requested by a robots.txt Crawl-delay directive).
+func (l *Limiter) SetCrawlDelay(host string, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b := l.bucket(host)
+	rate := 1 / delay.Seconds()
+	if b.rate <= 0 || rate < b.rate {
+		b.rate = rate
+	}
+
+	// a crawl delay means requests should be evenly spaced, so we don't allow
+	// any bursting.
+	b.burst = 1
+	if b.tokens > 1 {
+		b.tokens = 1
+	}
+}
+
+// Slowdown halves the request rate for the given host, and is called whenever
+// the host responds with a 429 Too Many Requests.
+func (l *Limiter) Slowdown(host string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b := l.bucket(host)
+	if b.rate <= 0 {
+		// the host was previously unthrottled, so we'll start from one request
+		// per second rather than trying to halve an infinite rate.
+		b.rate = 1
+	} else {
+		b.rate /= 2
+	}
+	if b.rate < minRate {
+		b.rate = minRate
+	}
+
+	b.burst = 1
+	if b.tokens > 1 {
+		b.tokens = 1
+	}
+}
+
+// Rate returns the current request rate (per second) for the given host.
+func (l *Limiter) Rate(host string) float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.bucket(host).rate
+}
+
+// Transport wraps a http.RoundTripper (http.DefaultTransport when nil) so that
+// every request made through it is throttled by the Limiter.
+//
+// note: the limiter is applied to the transport rather than the client, as a
+// http.Client follows redirects itself and so each hop of a redirect chain
+// (which can be to a different host) would otherwise go unthrottled.
+type Transport struct {
+	Transport http.RoundTripper
+	Limiter   *Limiter
+}
+
+// RoundTrip waits for the Limiter before making the request. The in-flight slot
+// for the host is only released once the response body has been closed.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	release, err := t.Limiter.Wait(req.Context(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return res, err
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		t.Limiter.Slowdown(host)
+	}
+
+	res.Body = &releaseCloser{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// releaseCloser calls release when the wrapped body is closed.
+type releaseCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (rc *releaseCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.release()
+	return err
+}
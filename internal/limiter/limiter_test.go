@@ -0,0 +1,219 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/integralist/go-web-crawler/internal/requester"
+)
+
+// recorder is a test server handler that keeps track of when each request was
+// received and how many requests were being handled concurrently.
+type recorder struct {
+	mutex       sync.Mutex
+	times       []time.Time
+	inFlight    int
+	maxInFlight int
+	status      int
+	hold        time.Duration
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mutex.Lock()
+	rec.times = append(rec.times, time.Now())
+	rec.inFlight++
+	if rec.inFlight > rec.maxInFlight {
+		rec.maxInFlight = rec.inFlight
+	}
+	rec.mutex.Unlock()
+
+	time.Sleep(rec.hold)
+
+	rec.mutex.Lock()
+	rec.inFlight--
+	rec.mutex.Unlock()
+
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+}
+
+// spacing returns the smallest gap between any two consecutive requests.
+func (rec *recorder) spacing() time.Duration {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	sort.Slice(rec.times, func(i, j int) bool { return rec.times[i].Before(rec.times[j]) })
+
+	min := time.Duration(-1)
+	for i := 1; i < len(rec.times); i++ {
+		if gap := rec.times[i].Sub(rec.times[i-1]); min < 0 || gap < min {
+			min = gap
+		}
+	}
+	return min
+}
+
+// throttled returns a client for the test server whose requests go through the
+// given limiter.
+func throttled(server *httptest.Server, l *Limiter) *http.Client {
+	return &http.Client{Transport: &Transport{Transport: server.Client().Transport, Limiter: l}}
+}
+
+// crawl concurrently requests the test server n times through the limiter.
+func crawl(url string, client requester.HTTPClient, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			if res, err := client.Do(req); err == nil {
+				res.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRequestSpacing(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	client := throttled(server, New(Config{Rate: 20, Burst: 1}))
+
+	crawl(server.URL, client, 6)
+
+	// 20 requests per second is one every 50ms, we allow a little slack for
+	// timer granularity.
+	if actual := rec.spacing(); actual < 45*time.Millisecond {
+		t.Errorf("expected spacing of at least: %+v\ngot: %+v", 45*time.Millisecond, actual)
+	}
+}
+
+func TestRedirectsAreThrottled(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.ServeHTTP(w, r)
+
+		// every request is redirected (e.g. /3 to /2) until we arrive at /0
+		if hops, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/")); hops > 0 {
+			http.Redirect(w, r, "/"+strconv.Itoa(hops-1), http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	client := throttled(server, New(Config{Rate: 20, Burst: 1}))
+
+	crawl(server.URL+"/3", client, 1)
+
+	if len(rec.times) != 4 {
+		t.Fatalf("expected: %+v\ngot: %+v", 4, len(rec.times))
+	}
+
+	// each hop of the redirect chain is a request of its own, and so is spaced
+	// out just like any other request.
+	if actual := rec.spacing(); actual < 45*time.Millisecond {
+		t.Errorf("expected spacing of at least: %+v\ngot: %+v", 45*time.Millisecond, actual)
+	}
+}
+
+func TestBurst(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	client := throttled(server, New(Config{Rate: 2, Burst: 4}))
+
+	start := time.Now()
+	crawl(server.URL, client, 4)
+
+	if actual := time.Since(start); actual > 250*time.Millisecond {
+		t.Errorf("expected burst to complete within: %+v\ngot: %+v", 250*time.Millisecond, actual)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	rec := &recorder{hold: 30 * time.Millisecond}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	client := throttled(server, New(Config{MaxInFlight: 2}))
+
+	crawl(server.URL, client, 8)
+
+	if rec.maxInFlight > 2 {
+		t.Errorf("expected at most: %+v\ngot: %+v", 2, rec.maxInFlight)
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	l := New(Config{Rate: 100, Burst: 10})
+	client := throttled(server, l)
+
+	host := server.Listener.Addr().String()
+	l.SetCrawlDelay(host, 80*time.Millisecond)
+
+	crawl(server.URL, client, 4)
+
+	if actual := rec.spacing(); actual < 75*time.Millisecond {
+		t.Errorf("expected spacing of at least: %+v\ngot: %+v", 75*time.Millisecond, actual)
+	}
+}
+
+func TestSlowdown(t *testing.T) {
+	rec := &recorder{status: http.StatusTooManyRequests}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	l := New(Config{Rate: 40, Burst: 1})
+	client := throttled(server, l)
+
+	host := server.Listener.Addr().String()
+
+	crawl(server.URL, client, 1)
+
+	if actual := l.Rate(host); actual != 20 {
+		t.Errorf("expected: %+v\ngot: %+v", 20, actual)
+	}
+
+	crawl(server.URL, client, 3)
+
+	// every 429 halves the rate (40 -> 20 -> 10 -> 5 -> 2.5)
+	if actual := l.Rate(host); actual != 2.5 {
+		t.Errorf("expected: %+v\ngot: %+v", 2.5, actual)
+	}
+
+	if actual := rec.spacing(); actual < 45*time.Millisecond {
+		t.Errorf("expected spacing of at least: %+v\ngot: %+v", 45*time.Millisecond, actual)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	l := New(Config{Rate: 1, Burst: 1, MaxInFlight: 1})
+
+	release, err := l.Wait(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := l.Wait(ctx, "www.example.com"); err != context.DeadlineExceeded {
+		t.Errorf("expected: %+v\ngot: %+v", context.DeadlineExceeded, err)
+	}
+}
@@ -11,14 +11,11 @@ package mapper
 
 import (
 	"sync"
-	"time"
 
-	"github.com/integralist/go-web-crawler/internal/instrumentator"
 	"github.com/integralist/go-web-crawler/internal/parser"
+	"github.com/integralist/go-web-crawler/internal/requester"
 )
 
-const defaultWorkerPool = 20
-
 // the mapper is executed concurrently, so we need appends to be thread-safe.
 var mutex = &sync.Mutex{}
 
@@ -26,39 +23,201 @@ var mutex = &sync.Mutex{}
 type Assets []string
 
 // Page represents the filtered elements of a HTML page (anchors/links/scripts).
+//
+// External holds the URLs found on the page that point to hosts we don't crawl,
+// Stylesheets holds the URLs of its stylesheets (so that they can be told apart
+// from its other links) and Hints holds those of its links (or external URLs)
+// that are only resource hints (see parser.Page). Status is the HTTP status code the page responded with (along with the
+// ContentType and Size of the response and the Title of the page).
+//
+// A URL that turned out to be something other than a HTML document (e.g. an
+// image or a PDF) is a leaf node, meaning it only has a ContentType and Size.
+//
+// Images, Media, Iframes, Objects and Forms hold the rest of the resources the
+// page depends on (see parser.Page), while CSSAssets holds the resources
+// referenced by the page's CSS (e.g. fonts, background images and @imported
+// stylesheets) whether inline or within one of its stylesheets.
+//
+// Description, MetaRobots, Lang, Alternates, Headings and WordCount describe
+// the page's content (see parser.Page), so that a crawl can double as an SEO
+// audit.
+//
+// LastModified, Canonical and NoIndex are used to determine whether (and how)
+// the page should appear in a sitemap, while NoFollow, XRobotsTag and
+// NoFollowURLs record the robots directives that determine whether its
+// anchors should be followed (see parser.Page).
+//
+// Redirects holds each hop that was followed before arriving at URL (the first
+// hop being the URL that was originally requested). When the redirects arrived
+// at a page that was crawled separately (e.g. another page links to it
+// directly) then URL is instead the URL that was requested and FinalURL is the
+// page it redirected to, meaning it only records the redirects.
 type Page struct {
-	Anchors Assets
-	Links   Assets
-	Scripts Assets
-	URL     string
+	Anchors      Assets
+	Links        Assets
+	Scripts      Assets
+	Images       Assets `json:",omitempty"`
+	Media        Assets `json:",omitempty"`
+	Iframes      Assets `json:",omitempty"`
+	Objects      Assets `json:",omitempty"`
+	Forms        Assets `json:",omitempty"`
+	CSSAssets    Assets `json:",omitempty"`
+	External     Assets `json:",omitempty"`
+	Stylesheets  Assets `json:",omitempty"`
+	Hints        Assets `json:",omitempty"`
+	URL          string
+	Status       int                `json:",omitempty"`
+	ContentType  string             `json:",omitempty"`
+	Size         int64              `json:",omitempty"`
+	Title        string             `json:",omitempty"`
+	Description  string             `json:",omitempty"`
+	MetaRobots   string             `json:",omitempty"`
+	Lang         string             `json:",omitempty"`
+	Alternates   []parser.Alternate `json:",omitempty"`
+	Headings     []parser.Heading   `json:",omitempty"`
+	WordCount    int                `json:",omitempty"`
+	LastModified string             `json:",omitempty"`
+	Canonical    string             `json:",omitempty"`
+	NoIndex      bool               `json:",omitempty"`
+	NoFollow     bool               `json:",omitempty"`
+	XRobotsTag   string             `json:",omitempty"`
+	NoFollowURLs Assets             `json:",omitempty"`
+	Depth        int
+	Redirects    []requester.Redirect `json:",omitempty"`
+	FinalURL     string               `json:",omitempty"`
+	RedirectLoop bool                 `json:",omitempty"`
+	Error        string               `json:",omitempty"`
+	Blocked      bool                 `json:",omitempty"`
 }
 
 // Map associates static assets with its parent web page.
 //
 // This function is expected to be executed concurrently, and so we wrap the
 // slice append calls with a mutex.
+//
+// note: duplicate URLs are only removed within each class of asset, as the same
+// URL can legitimately be used in more than one way (e.g. the common pattern of
+// `<a href="big.jpg"><img src="big.jpg"></a>` is both an anchor and an image,
+// and dropping it from the images would leave it out of the asset inventory).
 func Map(page parser.Page) Page {
-	var trackedURLs sync.Map
 	var anchors Assets
 	var links Assets
 	var scripts Assets
+	var external Assets
+	var stylesheets Assets
+	var hints Assets
+
+	anchors = appendWhenNotTracked("href", anchors, page.Anchors)
+	links = appendWhenNotTracked("href", links, page.Links)
+	scripts = appendWhenNotTracked("src", scripts, page.Scripts)
+
+	// note: the parser stores the URL of every other class of asset in a src
+	// attribute (whichever attribute it was originally found in).
+	images := appendWhenNotTracked("src", nil, page.Images)
+	media := appendWhenNotTracked("src", nil, page.Media)
+	iframes := appendWhenNotTracked("src", nil, page.Iframes)
+	objects := appendWhenNotTracked("src", nil, page.Objects)
+	forms := appendWhenNotTracked("src", nil, page.Forms)
+	cssAssets := appendWhenNotTracked("src", nil, page.CSSAssets)
+
+	trackedExternal := map[string]bool{}
+	for _, url := range page.External {
+		if !trackedExternal[url] {
+			trackedExternal[url] = true
+			external = append(external, url)
+		}
+	}
 
-	anchors = appendWhenNotTracked("href", anchors, page.Anchors, &trackedURLs)
-	links = appendWhenNotTracked("href", links, page.Links, &trackedURLs)
-	scripts = appendWhenNotTracked("src", scripts, page.Scripts, &trackedURLs)
+	trackedStylesheets := map[string]bool{}
+	for _, url := range page.Stylesheets {
+		if !trackedStylesheets[url] {
+			trackedStylesheets[url] = true
+			stylesheets = append(stylesheets, url)
+		}
+	}
+
+	trackedHints := map[string]bool{}
+	for _, url := range page.Hints {
+		if !trackedHints[url] {
+			trackedHints[url] = true
+			hints = append(hints, url)
+		}
+	}
 
 	return Page{
-		URL:     page.URL,
-		Anchors: anchors,
-		Links:   links,
-		Scripts: scripts,
+		URL:          page.URL,
+		Anchors:      anchors,
+		Links:        links,
+		Scripts:      scripts,
+		Images:       images,
+		Media:        media,
+		Iframes:      iframes,
+		Objects:      objects,
+		Forms:        forms,
+		CSSAssets:    cssAssets,
+		External:     external,
+		Stylesheets:  stylesheets,
+		Hints:        hints,
+		Status:       page.Status,
+		ContentType:  page.ContentType,
+		Size:         page.Size,
+		Title:        page.Title,
+		Description:  page.Description,
+		MetaRobots:   page.MetaRobots,
+		Lang:         page.Lang,
+		Alternates:   page.Alternates,
+		Headings:     page.Headings,
+		WordCount:    page.WordCount,
+		LastModified: page.LastModified,
+		Canonical:    page.Canonical,
+		NoIndex:      page.NoIndex,
+		NoFollow:     page.NoFollow,
+		XRobotsTag:   page.XRobotsTag,
+		NoFollowURLs: page.NoFollowURLs,
+		Redirects:    page.Redirects,
+		RedirectLoop: page.RedirectLoop,
+		Error:        page.Error,
+		Blocked:      page.Blocked,
+	}
+}
+
+// AppendCSSAssets adds the resources referenced by a page's stylesheets (which
+// are only known once the stylesheets have been requested) to the page, skipping
+// any that the page already references.
+func AppendCSSAssets(page Page, assets []string, external []string) Page {
+	tracked := map[string]bool{}
+	for _, collection := range []Assets{
+		page.Anchors, page.Links, page.Scripts, page.Images, page.Media,
+		page.Iframes, page.Objects, page.Forms, page.CSSAssets, page.External,
+	} {
+		for _, url := range collection {
+			tracked[url] = true
+		}
+	}
+
+	for _, url := range assets {
+		if !tracked[url] {
+			tracked[url] = true
+			page.CSSAssets = append(page.CSSAssets, url)
+		}
+	}
+
+	for _, url := range external {
+		if !tracked[url] {
+			tracked[url] = true
+			page.External = append(page.External, url)
+		}
 	}
+
+	return page
 }
 
 // a single page can repeatedly link to the same URL, so we don't bother
 // appending those URLs more than once (this makes reading the final JSON
 // output much cleaner).
-func appendWhenNotTracked(key string, collection Assets, assets parser.Assets, trackedURLs *sync.Map) Assets {
+func appendWhenNotTracked(key string, collection Assets, assets parser.Assets) Assets {
+	var trackedURLs sync.Map
+
 	for _, pageAssets := range assets {
 		for _, attr := range pageAssets.Attr {
 			if attr.Key == key {
@@ -77,50 +236,3 @@ func appendWhenNotTracked(key string, collection Assets, assets parser.Assets, t
 	}
 	return collection
 }
-
-// MapCollection concurrently maps a slice of parser.Page
-func MapCollection(pages []parser.Page, instr *instrumentator.Instr) []Page {
-	var wg sync.WaitGroup
-	var mappedPages []Page
-
-	// dynamically determine the worker pool size, we'll either set a default or
-	// use a smaller value if the number of tasks is smaller than the default.
-	toProcess := len(pages)
-	workerPool := defaultWorkerPool
-	if toProcess < defaultWorkerPool {
-		workerPool = toProcess
-	}
-
-	startTime := time.Now()
-	tasks := make(chan parser.Page, workerPool)
-
-	// spin up our worker pool as goroutines awaiting tasks to be processed
-	for i := 0; i < workerPool; i++ {
-		wg.Add(1)
-
-		go func(i int) {
-			defer wg.Done()
-
-			for page := range tasks {
-				mappedPage := Map(page)
-
-				// we use a mutex to ensure thread safety, not only for the correctness
-				// of the program but also because the Go language can trigger a panic!
-				mutex.Lock()
-				mappedPages = append(mappedPages, mappedPage)
-				mutex.Unlock()
-			}
-		}(i)
-	}
-
-	for _, page := range pages {
-		tasks <- page
-	}
-
-	close(tasks)
-
-	wg.Wait()
-	instr.Logger.Debug("time spent mapping:", time.Since(startTime))
-
-	return mappedPages
-}
@@ -1,6 +1,7 @@
 package mapper
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/integralist/go-web-crawler/internal/instrumentator"
@@ -110,3 +111,153 @@ func TestMap(t *testing.T) {
 		}
 	}
 }
+
+func TestMapAssets(t *testing.T) {
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+	parser.Init("http", "example.com", "www")
+
+	// the same image referenced by both a src and a srcset only appears once,
+	// whereas an image that's also linked to appears in both the anchors and the
+	// images.
+	page := requester.Page{
+		URL: "http://www.example.com",
+		Body: []byte(`<html>
+	<body>
+		<img src="/a.png" srcset="/a.png 1x, /a-2x.png 2x">
+		<a href="/big.jpg"><img src="/big.jpg"></a>
+		<video src="/b.mp4"></video>
+		<iframe src="/c.html"></iframe>
+		<embed src="/d.swf">
+		<form action="/search"></form>
+	</body>
+</html>`),
+		Status: 200,
+	}
+
+	actual := Map(parser.Parse(page, &instr))
+
+	for _, tc := range []struct {
+		name     string
+		actual   Assets
+		expected Assets
+	}{
+		{"anchors", actual.Anchors, Assets{"http://www.example.com/big.jpg"}},
+		{"images", actual.Images, Assets{"http://www.example.com/a.png", "http://www.example.com/a-2x.png", "http://www.example.com/big.jpg"}},
+		{"media", actual.Media, Assets{"http://www.example.com/b.mp4"}},
+		{"iframes", actual.Iframes, Assets{"http://www.example.com/c.html"}},
+		{"objects", actual.Objects, Assets{"http://www.example.com/d.swf"}},
+		{"forms", actual.Forms, Assets{"http://www.example.com/search"}},
+	} {
+		if len(tc.actual) != len(tc.expected) {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.name, tc.expected, tc.actual)
+			continue
+		}
+
+		for i, v := range tc.actual {
+			if v != tc.expected[i] {
+				t.Errorf("%s expected: %+v\ngot: %+v", tc.name, tc.expected[i], v)
+			}
+		}
+	}
+}
+
+func TestAppendCSSAssets(t *testing.T) {
+	page := Page{
+		URL:       "http://www.example.com/",
+		Links:     Assets{"http://www.example.com/main.css"},
+		Images:    Assets{"http://www.example.com/a.png"},
+		CSSAssets: Assets{"http://www.example.com/bg.png"},
+		External:  Assets{"https://fonts.example.org/a.woff2"},
+	}
+
+	// anything the page already references (in any class) isn't added again
+	actual := AppendCSSAssets(page, []string{
+		"http://www.example.com/main.css",
+		"http://www.example.com/a.png",
+		"http://www.example.com/bg.png",
+		"http://www.example.com/font.woff2",
+		"http://www.example.com/font.woff2",
+	}, []string{
+		"https://fonts.example.org/a.woff2",
+		"https://fonts.example.org/b.woff2",
+	})
+
+	expected := Assets{"http://www.example.com/bg.png", "http://www.example.com/font.woff2"}
+	if len(actual.CSSAssets) != len(expected) || actual.CSSAssets[1] != expected[1] {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual.CSSAssets)
+	}
+
+	expectedExternal := Assets{"https://fonts.example.org/a.woff2", "https://fonts.example.org/b.woff2"}
+	if len(actual.External) != len(expectedExternal) || actual.External[1] != expectedExternal[1] {
+		t.Errorf("expected: %+v\ngot: %+v", expectedExternal, actual.External)
+	}
+}
+
+func TestMapMetadata(t *testing.T) {
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+	parser.Init("http", "example.com", "www")
+
+	actual := Map(parser.Parse(requester.Page{
+		URL: "http://www.example.com",
+		Body: []byte(`<html lang="en">
+	<head>
+		<title>Home</title>
+		<meta name="description" content="The home page">
+		<meta name="robots" content="noindex, nofollow">
+		<link rel="alternate" hreflang="fr" href="/fr/">
+	</head>
+	<body><h1>Welcome</h1><p>Hello world</p></body>
+</html>`),
+		Status: 200,
+	}, &instr))
+
+	if actual.Title != "Home" || actual.Description != "The home page" || actual.Lang != "en" {
+		t.Errorf("expected: %+v\ngot: %+v %+v %+v", "Home, The home page, en", actual.Title, actual.Description, actual.Lang)
+	}
+
+	if actual.MetaRobots != "noindex, nofollow" || !actual.NoIndex {
+		t.Errorf("expected: %+v\ngot: %+v %+v", "noindex, nofollow", actual.MetaRobots, actual.NoIndex)
+	}
+
+	if len(actual.Alternates) != 1 || actual.Alternates[0].URL != "http://www.example.com/fr/" {
+		t.Errorf("expected: %+v\ngot: %+v", "http://www.example.com/fr/", actual.Alternates)
+	}
+
+	if len(actual.Headings) != 1 || actual.Headings[0].Text != "Welcome" || actual.WordCount != 3 {
+		t.Errorf("expected: %+v\ngot: %+v %+v", "a single heading and 3 words", actual.Headings, actual.WordCount)
+	}
+}
+
+func TestMapRobotsDirectives(t *testing.T) {
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+	parser.Init("http", "example.com", "www")
+
+	header := http.Header{}
+	header.Set("X-Robots-Tag", "nofollow")
+
+	actual := Map(parser.Parse(requester.Page{
+		URL:    "http://www.example.com",
+		Header: header,
+		Body: []byte(`<html><body>
+	<a href="/login" rel="nofollow">login</a>
+	<a href="/login" rel="nofollow">login again</a>
+	<a href="/about">about</a>
+</body></html>`),
+		Status: 200,
+	}, &instr))
+
+	if !actual.NoFollow || actual.NoIndex || actual.XRobotsTag != "nofollow" {
+		t.Errorf("expected: %+v %+v %+v\ngot: %+v %+v %+v", true, false, "nofollow", actual.NoFollow, actual.NoIndex, actual.XRobotsTag)
+	}
+
+	expected := Assets{"http://www.example.com/login"}
+	if len(actual.NoFollowURLs) != len(expected) || actual.NoFollowURLs[0] != expected[0] {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual.NoFollowURLs)
+	}
+}
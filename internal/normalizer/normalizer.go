@@ -0,0 +1,322 @@
+package normalizer
+
+// The normalizer package converts URLs into a canonical form, so that the same
+// page linked in slightly different ways (e.g. `HTTPS://Example.com:443/a/../b`
+// and `https://example.com/b`) is only tracked and crawled once.
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TrailingSlash determines how a trailing slash on a URL path is handled.
+type TrailingSlash string
+
+const (
+	// KeepSlash leaves the path untouched.
+	KeepSlash TrailingSlash = "keep"
+
+	// AddSlash appends a slash to paths that don't look like a file (i.e. the
+	// last segment has no extension).
+	AddSlash TrailingSlash = "add"
+
+	// StripSlash removes the trailing slash from every path other than `/`.
+	StripSlash TrailingSlash = "strip"
+)
+
+// QueryPolicy determines how the query string of a URL is handled.
+type QueryPolicy string
+
+const (
+	// KeepQuery keeps every query parameter.
+	KeepQuery QueryPolicy = "keep"
+
+	// DropQuery removes the query string entirely.
+	DropQuery QueryPolicy = "drop"
+
+	// DropListedQuery removes only the parameters listed in Config.DropParams.
+	DropListedQuery QueryPolicy = "drop-listed"
+)
+
+// Config describes the optional normalization steps.
+//
+// DropParams may contain a trailing `*` to match a parameter prefix, e.g.
+// `utm_*` matches both `utm_source` and `utm_medium`.
+type Config struct {
+	TrailingSlash TrailingSlash
+	Query         QueryPolicy
+	DropParams    []string
+}
+
+// DefaultConfig is used when the package hasn't been configured via Init.
+//
+// note: the trailing slash is kept by default, as the normalized URL is the
+// URL that's requested and most sites redirect to their preferred form (which
+// would result in a redirect for every page on a site that uses the other).
+var DefaultConfig = Config{
+	TrailingSlash: KeepSlash,
+	Query:         DropListedQuery,
+	DropParams:    []string{"utm_*", "fbclid", "gclid"},
+}
+
+// config is the configuration applied by Normalize.
+var config = DefaultConfig
+
+// defaultPorts are stripped from the host as they're implied by the scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Init configures the package from an outside mediator
+func Init(c Config) {
+	config = c
+}
+
+// ParseTrailingSlash validates a user provided trailing slash policy.
+func ParseTrailingSlash(s string) (TrailingSlash, error) {
+	switch ts := TrailingSlash(s); ts {
+	case KeepSlash, AddSlash, StripSlash:
+		return ts, nil
+	}
+	return "", fmt.Errorf("unknown trailing slash policy %q (expected %q, %q or %q)", s, KeepSlash, AddSlash, StripSlash)
+}
+
+// ParseQueryPolicy validates a user provided query policy.
+func ParseQueryPolicy(s string) (QueryPolicy, error) {
+	switch q := QueryPolicy(s); q {
+	case KeepQuery, DropQuery, DropListedQuery:
+		return q, nil
+	}
+	return "", fmt.Errorf("unknown query policy %q (expected %q, %q or %q)", s, KeepQuery, DropQuery, DropListedQuery)
+}
+
+// Resolve resolves a (possibly relative) reference against the base URL, as
+// described by RFC 3986, and returns the normalized result.
+func Resolve(base *url.URL, ref string) (*url.URL, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+
+	return Normalize(u), nil
+}
+
+// String normalizes a raw URL, returning it unchanged if it can't be parsed.
+func String(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return Normalize(u).String()
+}
+
+// Normalize returns a normalized copy of the given URL.
+//
+// The scheme and host are lowercased, default ports are removed, the percent
+// encoding of the path is normalized, dot-segments are removed from the path,
+// the fragment is dropped and the query parameters are sorted. The trailing
+// slash and query parameter handling is determined by the package configuration.
+//
+// note: the path is normalized in its escaped form, as decoding a reserved
+// character (e.g. `%2F` to `/`) would change which resource it refers to.
+func Normalize(u *url.URL) *url.URL {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	n.Fragment = ""
+	n.RawFragment = ""
+
+	if port := n.Port(); port != "" && defaultPorts[n.Scheme] == port {
+		n.Host = strings.TrimSuffix(n.Host, ":"+port)
+	}
+
+	// opaque URLs (e.g. mailto:) have no path for us to normalize
+	if n.Opaque != "" {
+		return &n
+	}
+
+	escaped := removeDotSegments(normalizeEscapes(n.EscapedPath()))
+	if escaped == "" && n.Host != "" {
+		escaped = "/"
+	}
+
+	switch config.TrailingSlash {
+	case StripSlash:
+		if len(escaped) > 1 {
+			escaped = strings.TrimRight(escaped, "/")
+			if escaped == "" {
+				escaped = "/"
+			}
+		}
+	case AddSlash:
+		if !strings.HasSuffix(escaped, "/") && path.Ext(escaped) == "" {
+			escaped += "/"
+		}
+	}
+
+	if p, err := url.PathUnescape(escaped); err == nil {
+		n.Path = p
+		n.RawPath = escaped
+	}
+
+	n.RawQuery = normalizeQuery(n.RawQuery)
+	n.ForceQuery = false
+
+	return &n
+}
+
+// normalizeQuery applies the query policy and sorts the remaining parameters.
+//
+// note: the parameters are sorted as they were written, rather than decoded and
+// re-encoded, as that can change their meaning (e.g. `?flag` isn't necessarily
+// the same as `?flag=`).
+func normalizeQuery(rawquery string) string {
+	if rawquery == "" || config.Query == DropQuery {
+		return ""
+	}
+
+	var params []string
+	for _, param := range strings.Split(rawquery, "&") {
+		if param == "" {
+			continue
+		}
+		if config.Query == DropListedQuery && dropParam(queryKey(param)) {
+			continue
+		}
+		params = append(params, param)
+	}
+
+	// note: the params are sorted by key, but the values for a repeated key are
+	// kept in their original order as that order can be significant.
+	sort.SliceStable(params, func(i, j int) bool {
+		return queryKey(params[i]) < queryKey(params[j])
+	})
+
+	return strings.Join(params, "&")
+}
+
+// queryKey returns the (decoded) key of a raw query parameter.
+func queryKey(param string) string {
+	key := strings.SplitN(param, "=", 2)[0]
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+// normalizeEscapes uppercases the hex digits of each percent encoded octet, and
+// decodes those that are unreserved characters, as described by RFC 3986
+// section 6.2.2.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		hex := strings.ToUpper(s[i+1 : i+3])
+		octet, err := strconv.ParseUint(hex, 16, 8)
+		if err != nil {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if c := byte(octet); unreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + hex)
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// unreserved reports whether a character can appear in a URL without being
+// percent encoded (RFC 3986 section 2.3).
+func unreserved(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// dropParam reports whether a query parameter is listed in DropParams.
+func dropParam(key string) bool {
+	for _, param := range config.DropParams {
+		if strings.HasSuffix(param, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(param, "*")) {
+				return true
+			}
+		} else if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDotSegments implements the algorithm from RFC 3986 section 5.2.4, which
+// removes `.` and `..` segments from a path.
+//
+// note: unlike path.Clean this keeps a trailing slash and doesn't collapse
+// repeated slashes, as either can change which resource a path refers to.
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+
+	var output []string
+	input := p
+
+	for input != "" {
+		switch {
+		case strings.HasPrefix(input, "../"):
+			input = input[3:]
+		case strings.HasPrefix(input, "./"):
+			input = input[2:]
+		case strings.HasPrefix(input, "/./"):
+			input = input[2:]
+		case input == "/.":
+			input = "/"
+		case strings.HasPrefix(input, "/../"):
+			input = input[3:]
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+		case input == "/..":
+			input = "/"
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+		case input == "." || input == "..":
+			input = ""
+		default:
+			// move the first path segment (including its leading slash, but not
+			// the next one) from the input to the output.
+			i := strings.Index(input[1:], "/")
+			if i < 0 {
+				output = append(output, input)
+				input = ""
+			} else {
+				output = append(output, input[:i+1])
+				input = input[i+1:]
+			}
+		}
+	}
+
+	return strings.Join(output, "")
+}
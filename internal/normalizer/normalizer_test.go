@@ -0,0 +1,77 @@
+package normalizer
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	defer Init(DefaultConfig)
+
+	for _, tc := range []struct {
+		config   Config
+		input    string
+		expected string
+	}{
+		{DefaultConfig, "HTTPS://WWW.Example.COM", "https://www.example.com/"},
+		{DefaultConfig, "https://www.example.com:443/foo", "https://www.example.com/foo"},
+		{DefaultConfig, "http://www.example.com:80/foo", "http://www.example.com/foo"},
+		{DefaultConfig, "http://www.example.com:8080/foo", "http://www.example.com:8080/foo"},
+		{DefaultConfig, "https://www.example.com/a/./b/../c/", "https://www.example.com/a/c/"},
+		{DefaultConfig, "https://www.example.com/posts/#comments", "https://www.example.com/posts/"},
+		{Config{TrailingSlash: StripSlash}, "https://www.example.com/posts/#comments", "https://www.example.com/posts"},
+		{DefaultConfig, "https://www.example.com/?b=2&a=1&utm_source=x&fbclid=y", "https://www.example.com/?a=1&b=2"},
+		{Config{TrailingSlash: KeepSlash, Query: KeepQuery}, "https://www.example.com/posts/?utm_source=x", "https://www.example.com/posts/?utm_source=x"},
+		{Config{TrailingSlash: AddSlash, Query: DropQuery}, "https://www.example.com/posts?page=2", "https://www.example.com/posts/"},
+		{Config{TrailingSlash: AddSlash}, "https://www.example.com/main.css", "https://www.example.com/main.css"},
+		{Config{TrailingSlash: StripSlash}, "https://www.example.com/", "https://www.example.com/"},
+		{Config{Query: DropListedQuery, DropParams: []string{"ref"}}, "https://www.example.com/?ref=a&reference=b", "https://www.example.com/?reference=b"},
+		{DefaultConfig, "https://www.example.com/a%2Fb", "https://www.example.com/a%2Fb"},
+		{DefaultConfig, "https://www.example.com/a%2fb/%7Euser/%41", "https://www.example.com/a%2Fb/~user/A"},
+		{Config{TrailingSlash: StripSlash}, "https://www.example.com/a%2F/", "https://www.example.com/a%2F"},
+		{DefaultConfig, "https://www.example.com/?flag&b=2", "https://www.example.com/?b=2&flag"},
+		{DefaultConfig, "https://www.example.com/?q=a+b&p=%2f&q=c", "https://www.example.com/?p=%2f&q=a+b&q=c"},
+	} {
+		Init(tc.config)
+
+		if actual := String(tc.input); actual != tc.expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.input, tc.expected, actual)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	base, _ := url.Parse("https://www.example.com/posts/foo/")
+
+	for ref, expected := range map[string]string{
+		"/about":               "https://www.example.com/about",
+		"../bar/":              "https://www.example.com/posts/bar/",
+		"next.html":            "https://www.example.com/posts/foo/next.html",
+		"//cdn.example.com/a":  "https://cdn.example.com/a",
+		"http://Other.com:80/": "http://other.com/",
+	} {
+		actual, err := Resolve(base, ref)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if actual.String() != expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", ref, expected, actual)
+		}
+	}
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	for input, expected := range map[string]string{
+		"/a/b/c/./../../g": "/a/g",
+		"/a/b/../../../g":  "/g",
+		"/a/./b/":          "/a/b/",
+		"/a/b/..":          "/a/",
+		"/a//b":            "/a//b",
+		"/no/dots":         "/no/dots",
+	} {
+		if actual := removeDotSegments(input); actual != expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", input, expected, actual)
+		}
+	}
+}
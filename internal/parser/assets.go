@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/integralist/go-web-crawler/internal/normalizer"
+	"golang.org/x/net/html"
+)
+
+// the classes of asset (other than anchors, links and scripts) that a page can
+// depend on.
+const (
+	images = iota
+	media
+	iframes
+	objects
+	forms
+	cssAssets
+)
+
+// assetAttribute is an attribute that references a resource, along with the
+// class of asset the resource is.
+type assetAttribute struct {
+	key   string
+	class int
+}
+
+// assetElements maps each element to the attributes that reference a resource.
+//
+// note: a <source> element references media when it's within a <video> or
+// <audio> element (via src) and images when it's within a <picture> element
+// (via srcset), and only an <input type="image"> element has a src.
+var assetElements = map[string][]assetAttribute{
+	"img":    {{"src", images}, {"srcset", images}},
+	"input":  {{"src", images}},
+	"source": {{"src", media}, {"srcset", images}},
+	"video":  {{"src", media}, {"poster", images}},
+	"audio":  {{"src", media}},
+	"track":  {{"src", media}},
+	"iframe": {{"src", iframes}},
+	"object": {{"data", objects}},
+	"embed":  {{"src", objects}},
+	"form":   {{"action", forms}},
+}
+
+// assetKey is the attribute that holds the URL of every asset token returned
+// by assetTokens (regardless of the element's original attribute).
+const assetKey = "src"
+
+// assetTokens returns a token for every resource referenced by the element
+// (i.e. one per srcset candidate) keyed by its class, along with the URLs of any
+// resources on hosts we don't crawl.
+//
+// the URLs are resolved and normalized in the same way as the anchors, links
+// and scripts, but the -include/-exclude patterns aren't applied to them.
+func assetTokens(t html.Token, base *url.URL) (map[int][]html.Token, []string) {
+	tokens := map[int][]html.Token{}
+	var external []string
+
+	for _, attr := range assetElements[t.Data] {
+		for _, a := range t.Attr {
+			if a.Key != attr.key {
+				continue
+			}
+
+			values := []string{a.Val}
+			if a.Key == "srcset" {
+				values = srcsetURLs(a.Val)
+			}
+
+			for _, value := range values {
+				u, internal, ok := classifyURL(value, base)
+				if !ok {
+					continue
+				}
+
+				if !internal {
+					external = append(external, u)
+					continue
+				}
+
+				tokens[attr.class] = append(tokens[attr.class], html.Token{
+					Type: t.Type,
+					Data: t.Data,
+					Attr: []html.Attribute{{Key: assetKey, Val: u}},
+				})
+			}
+		}
+	}
+
+	return tokens, external
+}
+
+// classifyURL resolves a reference to a resource and reports whether it's on
+// one of the hosts we crawl.
+func classifyURL(value string, base *url.URL) (string, bool, bool) {
+	u, ok := assetURL(value, base)
+	if !ok {
+		return "", false, false
+	}
+
+	if _, ok := ValidHosts[u.Host]; !ok {
+		return u.String(), false, true
+	}
+
+	// the user decides whether we crawl over HTTPS or HTTP, and so we normalize
+	// every valid URL to that protocol.
+	u.Scheme = protocol
+
+	return u.String(), true, true
+}
+
+// assetURL resolves an attribute value, ignoring empty values and anything
+// that can't be requested (e.g. a data: URI).
+func assetURL(value string, base *url.URL) (*url.URL, bool) {
+	value = cleanURL(value)
+	if value == "" {
+		return nil, false
+	}
+
+	u, err := normalizer.Resolve(base, value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// srcsetURLs returns the URL of each image candidate within a srcset attribute
+// (e.g. `a.png 1x, b.png 2x`).
+//
+// note: we follow the HTML specification rather than splitting on commas, as a
+// URL can itself contain commas (e.g. `/img/w_100,h_100/a.png 100w`).
+func srcsetURLs(srcset string) []string {
+	var urls []string
+
+	s := srcset
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return urls
+		}
+
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end == -1 {
+			end = len(s)
+		}
+		candidate := s[:end]
+		s = s[end:]
+
+		// a URL ending with a comma has no descriptors, otherwise we skip over the
+		// descriptors (e.g. `2x` or `100w`) up to the comma ending the candidate.
+		if strings.HasSuffix(candidate, ",") {
+			candidate = strings.TrimRight(candidate, ",")
+		} else {
+			s = skipDescriptors(s)
+		}
+
+		if candidate != "" {
+			urls = append(urls, candidate)
+		}
+	}
+}
+
+// skipDescriptors returns what remains of a srcset after the descriptors of the
+// current candidate (ignoring any commas within parentheses).
+func skipDescriptors(s string) string {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				return s[i+1:]
+			}
+		}
+	}
+	return ""
+}
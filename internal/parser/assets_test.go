@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSrcsetURLs(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected []string
+	}{
+		{"a.png", []string{"a.png"}},
+		{"a.png 1x, b.png 2x", []string{"a.png", "b.png"}},
+		{" a.png 100w,b.png 200w ", []string{"a.png", "b.png"}},
+		{"a.png, b.png 2x", []string{"a.png", "b.png"}},
+		{"a.png,b.png 2x", []string{"a.png,b.png"}},
+		{"/img/w_100,h_100/a.png 100w, /img/w_200,h_200/a.png 200w", []string{"/img/w_100,h_100/a.png", "/img/w_200,h_200/a.png"}},
+		{"a.png (foo, bar) 1x, b.png", []string{"a.png", "b.png"}},
+		{"", nil},
+	} {
+		actual := srcsetURLs(tc.input)
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("expected: %+v\ngot: %+v", tc.expected, actual)
+		}
+	}
+}
+
+func TestParseAssets(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL: "http://www.example.com/",
+		Body: []byte(`
+			<a href="/photo.png">photo</a>
+			<link rel="icon" href="/favicon.ico">
+			<img src="/a.png" srcset="/a-2x.png 2x, http://cdn.example.org/a-3x.png 3x">
+			<img src="data:image/png;base64,AAAA">
+			<picture><source srcset="/b.webp"><img src="/b.jpg"></picture>
+			<video src="/c.mp4" poster="/c.jpg"><source src="/c.webm"><track src="/c.vtt"></video>
+			<audio src="/d.mp3"></audio>
+			<iframe src="/e.html"></iframe>
+			<object data="/f.pdf"></object>
+			<embed src="/g.swf">
+			<form action="/search"></form>
+			<input type="image" src="/h.gif">
+		`),
+		Status: 200,
+	}, &instr)
+
+	for _, tc := range []struct {
+		name     string
+		assets   Assets
+		expected []string
+	}{
+		{"anchors", page.Anchors, []string{"http://www.example.com/photo.png"}},
+		{"links", page.Links, []string{"http://www.example.com/favicon.ico"}},
+		{"images", page.Images, []string{
+			"http://www.example.com/a.png",
+			"http://www.example.com/a-2x.png",
+			"http://www.example.com/b.webp",
+			"http://www.example.com/b.jpg",
+			"http://www.example.com/c.jpg",
+			"http://www.example.com/h.gif",
+		}},
+		{"media", page.Media, []string{"http://www.example.com/c.mp4", "http://www.example.com/c.webm", "http://www.example.com/c.vtt", "http://www.example.com/d.mp3"}},
+		{"iframes", page.Iframes, []string{"http://www.example.com/e.html"}},
+		{"objects", page.Objects, []string{"http://www.example.com/f.pdf", "http://www.example.com/g.swf"}},
+		{"forms", page.Forms, []string{"http://www.example.com/search"}},
+	} {
+		var actual []string
+		for _, token := range tc.assets {
+			for _, attr := range token.Attr {
+				if attr.Key == "href" || attr.Key == "src" {
+					actual = append(actual, attr.Val)
+				}
+			}
+		}
+
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.name, tc.expected, actual)
+		}
+	}
+
+	if len(page.External) != 1 || page.External[0] != "http://cdn.example.org/a-3x.png" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://cdn.example.org/a-3x.png"}, page.External)
+	}
+}
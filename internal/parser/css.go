@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// cssComment matches a CSS comment (which could contain a commented out url).
+var cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// cssURL matches a url() function, where the URL may or may not be quoted.
+var cssURL = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+
+// cssImport matches an @import rule using a string (the url() form is already
+// matched by cssURL).
+var cssImport = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^)"'\s;]+))`)
+
+// ScanCSS returns the URLs referenced within a stylesheet (e.g. fonts,
+// background images and other stylesheets), along with the subset of those
+// that are stylesheets imported using @import.
+//
+// note: references to an element within the same document (e.g. an SVG filter
+// such as `url(#blur)`) aren't resources, and so are skipped.
+func ScanCSS(css string) (refs []string, imports []string) {
+	css = cssComment.ReplaceAllString(css, "")
+
+	seen := map[string]bool{}
+	add := func(ref string) bool {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || strings.HasPrefix(ref, "#") || seen[ref] {
+			return false
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+		return true
+	}
+
+	for _, m := range cssImport.FindAllStringSubmatch(css, -1) {
+		ref := m[1] + m[2] + m[3]
+		if add(ref) {
+			imports = append(imports, strings.TrimSpace(ref))
+		}
+	}
+
+	for _, m := range cssURL.FindAllStringSubmatch(css, -1) {
+		add(m[1] + m[2] + m[3])
+	}
+
+	return refs, imports
+}
+
+// StylesheetURLs resolves the URLs referenced within a stylesheet against the
+// URL it was served from, returning the URLs on the hosts we crawl (and the
+// stylesheets they import) separately from those on external hosts.
+func StylesheetURLs(css string, stylesheetURL string) (assets []string, imports []string, external []string) {
+	base, err := url.Parse(stylesheetURL)
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	refs, importRefs := ScanCSS(css)
+
+	imported := map[string]bool{}
+	for _, ref := range importRefs {
+		imported[ref] = true
+	}
+
+	for _, ref := range refs {
+		u, internal, ok := classifyURL(ref, base)
+		if !ok {
+			continue
+		}
+
+		if !internal {
+			external = append(external, u)
+			continue
+		}
+
+		assets = append(assets, u)
+		if imported[ref] {
+			imports = append(imports, u)
+		}
+	}
+
+	return assets, imports, external
+}
+
+// inlineCSSTokens returns a token for every resource referenced within inline
+// CSS (i.e. a <style> element or a style attribute), along with the URLs of
+// the stylesheets it imports and of any resources on hosts we don't crawl.
+func inlineCSSTokens(css string, base *url.URL) ([]html.Token, []string, []string) {
+	var tokens []html.Token
+	var imports []string
+	var external []string
+
+	refs, importRefs := ScanCSS(css)
+
+	imported := map[string]bool{}
+	for _, ref := range importRefs {
+		imported[ref] = true
+	}
+
+	for _, ref := range refs {
+		u, internal, ok := classifyURL(ref, base)
+		if !ok {
+			continue
+		}
+
+		if !internal {
+			external = append(external, u)
+			continue
+		}
+
+		tokens = append(tokens, html.Token{
+			Type: html.StartTagToken,
+			Data: "style",
+			Attr: []html.Attribute{{Key: assetKey, Val: u}},
+		})
+
+		if imported[ref] {
+			imports = append(imports, u)
+		}
+	}
+
+	return tokens, imports, external
+}
@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+func TestScanCSS(t *testing.T) {
+	css := `
+		@import "base.css";
+		@import url('print.css') print;
+		@IMPORT theme.css;
+		/* background: url(commented.png); */
+		body { background: url( "bg.png" ) no-repeat, url(bg.png); }
+		@font-face { src: url(fonts/a.woff2) format("woff2"), url('fonts/a.woff') format("woff"); }
+		.blur { filter: url(#blur); }
+		.empty { background: url(); }
+	`
+
+	refs, imports := ScanCSS(css)
+
+	expectedRefs := []string{"base.css", "print.css", "theme.css", "bg.png", "fonts/a.woff2", "fonts/a.woff"}
+	if strings.Join(refs, " ") != strings.Join(expectedRefs, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expectedRefs, refs)
+	}
+
+	expectedImports := []string{"base.css", "print.css", "theme.css"}
+	if strings.Join(imports, " ") != strings.Join(expectedImports, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expectedImports, imports)
+	}
+}
+
+func TestStylesheetURLs(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	css := `
+		@import "../vendor/reset.css";
+		body { background: url(/img/bg.png); }
+		@font-face { src: url(fonts/a.woff2), url(https://fonts.example.org/b.woff2); }
+		.icon { background: url(data:image/png;base64,AAAA); }
+	`
+
+	// relative references are resolved against the stylesheet (which uses the
+	// other protocol to ensure the assets are normalized to ours).
+	assets, imports, external := StylesheetURLs(css, "https://www.example.com/static/css/main.css")
+
+	expectedAssets := []string{
+		"http://www.example.com/static/vendor/reset.css",
+		"http://www.example.com/img/bg.png",
+		"http://www.example.com/static/css/fonts/a.woff2",
+	}
+	if strings.Join(assets, " ") != strings.Join(expectedAssets, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expectedAssets, assets)
+	}
+
+	if len(imports) != 1 || imports[0] != expectedAssets[0] {
+		t.Errorf("expected: %+v\ngot: %+v", expectedAssets[:1], imports)
+	}
+
+	if len(external) != 1 || external[0] != "https://fonts.example.org/b.woff2" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"https://fonts.example.org/b.woff2"}, external)
+	}
+}
+
+func TestParseInlineCSS(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL: "http://www.example.com/posts/",
+		Body: []byte(`
+			<link rel="stylesheet" href="/main.css">
+			<link rel="alternate stylesheet" href="/dark.css">
+			<link rel="icon" href="/favicon.ico">
+			<style>
+				@import "print.css";
+				.hero { background: url(/hero.jpg); }
+				.logo { background: url(http://cdn.example.org/logo.svg); }
+			</style>
+			<div style="background-image: url('bg.png')"><a href="/about" style="color: red">about</a></div>
+		`),
+		Status: 200,
+	}, &instr)
+
+	var actual []string
+	for _, token := range page.CSSAssets {
+		for _, attr := range token.Attr {
+			if attr.Key == "src" {
+				actual = append(actual, attr.Val)
+			}
+		}
+	}
+
+	expected := []string{
+		"http://www.example.com/posts/print.css",
+		"http://www.example.com/hero.jpg",
+		"http://www.example.com/posts/bg.png",
+	}
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+	}
+
+	expectedStylesheets := []string{"http://www.example.com/main.css", "http://www.example.com/dark.css", "http://www.example.com/posts/print.css"}
+	if strings.Join(page.Stylesheets, " ") != strings.Join(expectedStylesheets, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expectedStylesheets, page.Stylesheets)
+	}
+
+	// an element with a style attribute is still processed as normal
+	if len(page.Anchors) != 1 {
+		t.Errorf("expected: %+v\ngot: %+v", 1, len(page.Anchors))
+	}
+
+	if len(page.External) != 1 || page.External[0] != "http://cdn.example.org/logo.svg" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://cdn.example.org/logo.svg"}, page.External)
+	}
+}
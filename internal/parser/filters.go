@@ -2,55 +2,243 @@ package parser
 
 import (
 	"fmt"
+	"mime"
+	"net/http"
 	"net/url"
+	"path"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/normalizer"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/robots"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
 )
 
-var imagePattern, _ = regexp.Compile("(?:doc|ico|pdf|gif|jpg|png)")
+// nonHTMLExtensions are the file extensions that identify a URL as something
+// other than a HTML document (e.g. an image, a document or an archive).
+var nonHTMLExtensions = map[string]bool{
+	// images
+	".avif": true, ".bmp": true, ".gif": true, ".ico": true, ".jpeg": true,
+	".jpg": true, ".png": true, ".svg": true, ".tif": true, ".tiff": true,
+	".webp": true,
+	// documents
+	".csv": true, ".doc": true, ".docx": true, ".epub": true, ".odp": true,
+	".ods": true, ".odt": true, ".pdf": true, ".ppt": true, ".pptx": true,
+	".rtf": true, ".txt": true, ".xls": true, ".xlsx": true,
+	// archives and binaries
+	".7z": true, ".apk": true, ".bz2": true, ".deb": true, ".dmg": true,
+	".exe": true, ".gz": true, ".iso": true, ".msi": true, ".rar": true,
+	".rpm": true, ".tar": true, ".tgz": true, ".xz": true, ".zip": true,
+	// audio and video
+	".avi": true, ".flac": true, ".m4a": true, ".m4v": true, ".mkv": true,
+	".mov": true, ".mp3": true, ".mp4": true, ".oga": true, ".ogg": true,
+	".ogv": true, ".wav": true, ".webm": true,
+	// fonts
+	".eot": true, ".otf": true, ".ttf": true, ".woff": true, ".woff2": true,
+	// code and data
+	".css": true, ".js": true, ".json": true, ".map": true, ".rss": true,
+	".wasm": true, ".xml": true,
+}
+
+// NonHTML reports whether a URL's extension identifies it as something other
+// than a HTML document (e.g. `/report.pdf`), meaning it's a leaf node that
+// there's no need to download.
+//
+// note: only the extension of the final path segment counts, and so a page
+// such as `/docs/` or `/icons-guide` isn't mistaken for a resource.
+func NonHTML(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	return nonHTMLExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// regexPrefix identifies a user provided pattern as a regular expression rather
+// than a glob.
+const regexPrefix = "re:"
+
+// Pattern is a compiled -include or -exclude pattern.
+type Pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// String returns the pattern as originally provided by the user.
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether the given URL path (and optional query) matches.
+func (p Pattern) Match(s string) bool {
+	return p.re.MatchString(s)
+}
+
+// CompilePattern compiles either a glob (e.g. `/tags/**`) or, when prefixed
+// with `re:`, a regular expression (e.g. `re:^/tags/`).
+//
+// Within a glob `*` matches any characters other than `/`, `**` matches any
+// characters at all and `?` matches a single character other than `/`. A glob
+// must match the entire path, whereas a regular expression only has to match
+// part of it (unless it is anchored).
+func CompilePattern(s string) (Pattern, error) {
+	if strings.HasPrefix(s, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(s, regexPrefix))
+		if err != nil {
+			return Pattern{}, err
+		}
+		return Pattern{raw: s, re: re}, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '*' && i+1 < len(s) && s[i+1] == '*':
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return Pattern{}, err
+	}
+	return Pattern{raw: s, re: re}, nil
+}
+
+// include, exclude and matchQuery are set via InitFilters
+var (
+	include    []Pattern
+	exclude    []Pattern
+	matchQuery bool
+)
+
+// excludedURLs tracks the URLs skipped due to the -include/-exclude patterns,
+// along with the reason they were skipped, so they can be summarised.
+var excludedURLs = new(sync.Map)
+
+// notIncluded is the reason recorded for URLs that didn't match any of the
+// -include patterns.
+const notIncluded = "not matched by any -include pattern"
+
+// InitFilters configures the -include/-exclude patterns that anchors must
+// satisfy in order to be crawled.
+func InitFilters(includes, excludes []string, query bool) error {
+	include = nil
+	exclude = nil
+	matchQuery = query
+	excludedURLs = new(sync.Map)
+
+	for _, s := range includes {
+		p, err := CompilePattern(s)
+		if err != nil {
+			return fmt.Errorf("invalid -include pattern %q: %s", s, err)
+		}
+		include = append(include, p)
+	}
+
+	for _, s := range excludes {
+		p, err := CompilePattern(s)
+		if err != nil {
+			return fmt.Errorf("invalid -exclude pattern %q: %s", s, err)
+		}
+		exclude = append(exclude, p)
+	}
 
-// we want to ignore urls with fragments and parsing external domains
-func excludeInvalidURLs(token *html.Token, key string, instr *instrumentator.Instr) bool {
+	return nil
+}
+
+// Excluded returns the number of unique URLs skipped for each reason.
+func Excluded() map[string]int {
+	counts := map[string]int{}
+	excludedURLs.Range(func(key, value interface{}) bool {
+		counts[value.(string)]++
+		return true
+	})
+	return counts
+}
+
+// filtered checks a URL against the -include/-exclude patterns, returning the
+// reason it should be skipped (or an empty string if it should be crawled).
+func filtered(u *url.URL) string {
+	target := u.Path
+	if matchQuery && u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	for _, p := range exclude {
+		if p.Match(target) {
+			return "excluded by " + p.String()
+		}
+	}
+
+	if len(include) == 0 {
+		return ""
+	}
+
+	for _, p := range include {
+		if p.Match(target) {
+			return ""
+		}
+	}
+
+	return notIncluded
+}
+
+// we want to ignore urls we can't parse and urls for external domains.
+//
+// the attribute value is resolved against the base URL of the page it was found
+// on (as per RFC 3986) and then normalized (e.g. fragments are removed), so that
+// the same page linked in different ways results in the same hash table key.
+//
+// note: non-HTTP schemes such as mailto: or javascript: have no host, and so
+// they're excluded by the valid hosts check.
+func excludeInvalidURLs(token *html.Token, key string, base *url.URL, instr *instrumentator.Instr) bool {
 	for i, a := range token.Attr {
 		if a.Key == key {
-			rawurl := a.Val
+			rawurl := cleanURL(a.Val)
 
 			// TODO: figure out better way to do this type of thing, as I feel this
 			// is tightly coupling us to logrus.
 			log := instr.Logger.WithFields(logrus.Fields{"url": rawurl})
 
-			url, err := url.Parse(rawurl)
+			url, err := normalizer.Resolve(base, rawurl)
 			if err != nil {
 				log.Debug("URL_INVALID")
 				return true
 			}
 
-			if imagePattern.MatchString(url.Path) {
+			if _, ok := ValidHosts[url.Host]; !ok {
 				log.Debug("URL_INVALID")
 				return true
 			}
 
-			// normalize the host information
-			if url.Host == "" || url.Host == hostname {
-				url.Host = fmt.Sprintf("www.%s", hostname)
+			// the user decides whether we crawl over HTTPS or HTTP, and so we
+			// normalize every valid URL to that protocol.
+			url.Scheme = protocol
+			token.Attr[i].Val = url.String()
 
-				prefix := "/"
-				if strings.HasPrefix(a.Val, "/") {
-					prefix = ""
+			// the -include/-exclude patterns only determine which pages we crawl,
+			// and so they're not applied to static assets.
+			if token.Data == "a" {
+				if reason := filtered(url); reason != "" {
+					log.WithFields(logrus.Fields{"reason": reason}).Debug("URL_EXCLUDED")
+					excludedURLs.LoadOrStore(token.Attr[i].Val, reason)
+					return true
 				}
-
-				// note: using url.Path will remove any incidents where the same url
-				// has a fragment causing the hash table key to change
-				token.Attr[i].Val = fmt.Sprintf("%s://%s%s%s", protocol, url.Host, prefix, url.Path)
-			}
-
-			if _, ok := ValidHosts[url.Host]; !ok {
-				log.Debug("URL_INVALID")
-				return true
 			}
 
 			return false
@@ -60,6 +248,74 @@ func excludeInvalidURLs(token *html.Token, key string, instr *instrumentator.Ins
 	return true
 }
 
+// ValidURL applies the same rules to a URL found outside of a page (e.g. in a
+// sitemap) as are applied to anchors, returning the normalized URL if it
+// should be crawled.
+func ValidURL(rawurl string) (string, bool) {
+	u, err := normalizer.Resolve(nil, cleanURL(rawurl))
+	if err != nil {
+		return "", false
+	}
+
+	if _, ok := ValidHosts[u.Host]; !ok {
+		return "", false
+	}
+
+	u.Scheme = protocol
+
+	if reason := filtered(u); reason != "" {
+		excludedURLs.LoadOrStore(u.String(), reason)
+		return "", false
+	}
+
+	return u.String(), true
+}
+
+// externalURL returns the resolved URL of an attribute that points to a host
+// we don't crawl (non-HTTP schemes such as mailto: aren't considered external
+// as there's nothing we can request).
+func externalURL(token html.Token, key string, base *url.URL) (string, bool) {
+	for _, a := range token.Attr {
+		if a.Key != key {
+			continue
+		}
+
+		u, err := normalizer.Resolve(base, cleanURL(a.Val))
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return "", false
+		}
+
+		if _, ok := ValidHosts[u.Host]; ok {
+			return "", false
+		}
+
+		return u.String(), true
+	}
+
+	return "", false
+}
+
+// cleanURL strips the whitespace that browsers ignore from an attribute value,
+// i.e. leading/trailing whitespace and any tabs or newlines within the URL.
+func cleanURL(s string) string {
+	s = strings.TrimSpace(s)
+	return strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(s)
+}
+
+// baseURL returns the URL specified by a <base href="..."> element (resolved
+// against the page URL), or the page URL if the element has no valid href (as
+// indicated by the returned bool).
+func baseURL(pageURL *url.URL, attr []html.Attribute) (*url.URL, bool) {
+	for _, a := range attr {
+		if a.Key == "href" {
+			if ref, err := url.Parse(cleanURL(a.Val)); err == nil {
+				return pageURL.ResolveReference(ref), true
+			}
+		}
+	}
+	return pageURL, false
+}
+
 // some script tags have inline code and aren't external references
 func missingScriptSrc(attr []html.Attribute) bool {
 	for _, a := range attr {
@@ -72,10 +328,135 @@ func missingScriptSrc(attr []html.Attribute) bool {
 
 // a link can actually just contain rel='canonical' and not link to a css file
 func canonical(attr []html.Attribute) bool {
+	return hasRel(attr, "canonical")
+}
+
+// resourceHints are the link types that only hint at what a browser might need
+// next (e.g. an origin to connect to) rather than referencing a resource the
+// page depends on, and so they needn't respond successfully to a request.
+var resourceHints = map[string]bool{
+	"dns-prefetch": true,
+	"preconnect":   true,
+	"prefetch":     true,
+	"preload":      true,
+	"prerender":    true,
+}
+
+// resourceHint reports whether a <link> element is a resource hint.
+func resourceHint(attr []html.Attribute) bool {
+	for _, value := range strings.Fields(strings.ToLower(attribute(attr, "rel"))) {
+		if resourceHints[value] {
+			return true
+		}
+	}
+	return false
+}
+
+// stylesheetHref returns the (already resolved) href of a link to a stylesheet.
+func stylesheetHref(attr []html.Attribute) (string, bool) {
+	href := attribute(attr, "href")
+	return href, hasRel(attr, "stylesheet") && href != ""
+}
+
+// canonicalHref resolves the href of a <link rel="canonical"> element, using
+// the user's protocol for valid hosts (just as we do for anchors) so that it
+// can be compared against the URL of the page.
+func canonicalHref(attr []html.Attribute, base *url.URL) string {
 	for _, a := range attr {
-		if a.Key == "rel" && a.Val == "canonical" {
+		if a.Key != "href" {
+			continue
+		}
+
+		u, err := normalizer.Resolve(base, cleanURL(a.Val))
+		if err != nil {
+			return ""
+		}
+
+		if _, ok := ValidHosts[u.Host]; ok {
+			u.Scheme = protocol
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// headerRobots returns the directives of the X-Robots-Tag headers that apply
+// to us.
+//
+// note: a directive can be prefixed with the user agent it applies to (e.g.
+// `googlebot: noindex`) in which case we ignore it unless it's meant for us.
+func headerRobots(header http.Header) []string {
+	var directives []string
+	for _, value := range header.Values("X-Robots-Tag") {
+		if i := strings.Index(value, ":"); i >= 0 {
+			agent := strings.ToLower(strings.TrimSpace(value[:i]))
+			if agent != robots.UserAgent {
+				continue
+			}
+			value = value[i+1:]
+		}
+
+		if value = strings.TrimSpace(value); value != "" {
+			directives = append(directives, value)
+		}
+	}
+	return directives
+}
+
+// noIndexDirective reports whether a comma separated list of robots directives
+// prevents the page from being indexed (`none` is equivalent to `noindex,
+// nofollow`).
+func noIndexDirective(content string) bool {
+	return robotsDirective(content, "noindex")
+}
+
+// noFollowDirective reports whether a comma separated list of robots directives
+// asks for the page's links not to be followed.
+func noFollowDirective(content string) bool {
+	return robotsDirective(content, "nofollow")
+}
+
+// robotsDirective reports whether a comma separated list of robots directives
+// includes the given directive (or `none`, which implies every restriction).
+func robotsDirective(content string, directive string) bool {
+	for _, d := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case directive, "none":
 			return true
 		}
 	}
 	return false
 }
+
+// LastModified converts a Last-Modified header into the W3C datetime format
+// used by sitemaps (an invalid or missing header results in an empty string).
+func LastModified(header http.Header) string {
+	t, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// size returns the length of a response in bytes (or zero when unknown).
+func size(page requester.Page) int64 {
+	if page.Size > 0 {
+		return page.Size
+	}
+	return int64(len(page.Body))
+}
+
+// contentType returns the media type of a response, which the requester will
+// have determined (sniffing the body if need be) unless the page was built by
+// hand, in which case we fall back to its Content-Type header.
+func contentType(page requester.Page) string {
+	if page.ContentType != "" {
+		return page.ContentType
+	}
+
+	mediaType, _, err := mime.ParseMediaType(page.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"/tags/*", "/tags/go", true},
+		{"/tags/*", "/tags/go/", false},
+		{"/tags/**", "/tags/go/", true},
+		{"/tags/**", "/posts/tags/go", false},
+		{"/posts/page/?/", "/posts/page/2/", true},
+		{"/posts/page/?/", "/posts/page/10/", false},
+		{"/a.b", "/axb", false},
+		{"re:^/tags/", "/tags/go/", true},
+		{"re:/page/[0-9]+", "/posts/page/10/", true},
+		{"re:^/page/", "/posts/page/10/", false},
+	} {
+		p, err := CompilePattern(tc.pattern)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if actual := p.Match(tc.path); actual != tc.expected {
+			t.Errorf("%s %s expected: %+v\ngot: %+v", tc.pattern, tc.path, tc.expected, actual)
+		}
+	}
+
+	if _, err := CompilePattern("re:("); err == nil {
+		t.Errorf("expected an invalid regular expression to return an error")
+	}
+}
+
+func TestFiltered(t *testing.T) {
+	defer InitFilters(nil, nil, false)
+
+	if err := InitFilters([]string{"/posts/**"}, []string{"/posts/drafts/**", "re:page=[0-9]+"}, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for rawurl, expected := range map[string]string{
+		"http://www.example.com/posts/foo/":         "",
+		"http://www.example.com/posts/drafts/foo/":  "excluded by /posts/drafts/**",
+		"http://www.example.com/posts/?page=2":      "excluded by re:page=[0-9]+",
+		"http://www.example.com/about/":             notIncluded,
+		"http://www.example.com/posts/foo/?utm=bar": "",
+	} {
+		u, _ := url.Parse(rawurl)
+
+		if actual := filtered(u); actual != expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", rawurl, expected, actual)
+		}
+	}
+}
+
+func TestNonHTML(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected bool
+	}{
+		{"http://www.example.com/photo.png", true},
+		{"http://www.example.com/files/Report.PDF", true},
+		{"http://www.example.com/archive.tar.gz?v=1", true},
+		{"http://www.example.com/docs/", false},
+		{"http://www.example.com/docs/intro", false},
+		{"http://www.example.com/icons-guide", false},
+		{"http://www.example.com/png/", false},
+		{"http://www.example.com/about.html", false},
+		{"http://www.example.com/", false},
+	} {
+		if actual := NonHTML(tc.input); actual != tc.expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.input, tc.expected, actual)
+		}
+	}
+}
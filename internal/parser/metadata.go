@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/integralist/go-web-crawler/internal/robots"
+	"golang.org/x/net/html"
+)
+
+// Heading is a single <h1>–<h6> element, which in document order make up the
+// page's outline.
+type Heading struct {
+	Level int
+	Text  string
+}
+
+// Alternate is a <link rel="alternate" hreflang="..."> element, i.e. a version
+// of the page in a different language (or for a different region).
+type Alternate struct {
+	Lang string
+	URL  string
+}
+
+// headingLevels maps each heading element to its level.
+var headingLevels = map[string]int{
+	"h1": 1, "h2": 2, "h3": 3, "h4": 4, "h5": 5, "h6": 6,
+}
+
+// hiddenText are the elements whose text isn't part of the page's content, and
+// so isn't included in its word count.
+var hiddenText = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"iframe":   true,
+	"noembed":  true,
+	"noframes": true,
+}
+
+// collapse trims the text and replaces every run of whitespace within it with a
+// single space (just as a browser would render it).
+func collapse(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// attribute returns the value of an element's attribute (attribute names are
+// always lowercased by the tokenizer).
+func attribute(attr []html.Attribute, key string) string {
+	for _, a := range attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasRel reports whether an element's rel attribute includes the given link
+// type (rel is a case-insensitive, space separated list e.g. `alternate
+// stylesheet`).
+func hasRel(attr []html.Attribute, rel string) bool {
+	for _, value := range strings.Fields(strings.ToLower(attribute(attr, "rel"))) {
+		if value == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// metaName returns the (lowercased) name of a <meta> element along with its
+// content.
+func metaName(attr []html.Attribute) (string, string) {
+	return strings.ToLower(strings.TrimSpace(attribute(attr, "name"))), attribute(attr, "content")
+}
+
+// metaRobots returns the directives of a <meta> element that is a robots
+// directive, either for all robots or for us specifically.
+func metaRobots(attr []html.Attribute) (string, bool) {
+	name, content := metaName(attr)
+	if name != "robots" && name != robots.UserAgent {
+		return "", false
+	}
+	return strings.TrimSpace(content), true
+}
+
+// hreflangAlternate resolves a <link rel="alternate" hreflang="..."> element.
+//
+// note: the alternate URLs are resolved in the same way as the canonical URL,
+// as a site's translations are frequently on a host we don't crawl.
+func hreflangAlternate(attr []html.Attribute, base *url.URL) (Alternate, bool) {
+	lang := strings.TrimSpace(attribute(attr, "hreflang"))
+	if lang == "" || !hasRel(attr, "alternate") {
+		return Alternate{}, false
+	}
+
+	href := canonicalHref(attr, base)
+	if href == "" {
+		return Alternate{}, false
+	}
+
+	return Alternate{Lang: lang, URL: href}, true
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseMetadata(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL: "http://www.example.com/posts/",
+		Body: []byte(`<!doctype html>
+<html lang="en-GB">
+<head>
+	<title>Posts</title>
+	<meta name="Description" content="  All of the
+		posts ">
+	<meta name="description" content="ignored">
+	<meta name="robots" content="noarchive">
+	<meta name="googlebot" content="noindex">
+	<link rel="canonical" href="/posts">
+	<link rel="alternate" hreflang="de" href="https://de.example.org/posts/">
+	<link rel="Alternate" hreflang="x-default" href="/posts/">
+	<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+	<style>h1 { color: red }</style>
+	<script>var words = "not counted";</script>
+</head>
+<body>
+	<h1>All <em>the</em> posts</h1>
+	<p>Some words here.</p>
+	<h2>First</h2>
+	<h3>
+		Nested
+	</h3>
+	<noscript>enable javascript</noscript>
+	<h2>Second<h3>Unclosed</h3>
+</body>
+</html>`),
+		Status: 200,
+	}, &instr)
+
+	if page.Description != "All of the posts" {
+		t.Errorf("expected: %+v\ngot: %+v", "All of the posts", page.Description)
+	}
+
+	// a robots directive meant for another crawler is ignored
+	if page.MetaRobots != "noarchive" || page.NoIndex {
+		t.Errorf("expected: %+v\ngot: %+v %+v", "noarchive", page.MetaRobots, page.NoIndex)
+	}
+
+	if page.Lang != "en-GB" {
+		t.Errorf("expected: %+v\ngot: %+v", "en-GB", page.Lang)
+	}
+
+	if page.Canonical != "http://www.example.com/posts" {
+		t.Errorf("expected: %+v\ngot: %+v", "http://www.example.com/posts", page.Canonical)
+	}
+
+	expectedAlternates := []Alternate{
+		{Lang: "de", URL: "https://de.example.org/posts/"},
+		{Lang: "x-default", URL: "http://www.example.com/posts/"},
+	}
+	if len(page.Alternates) != len(expectedAlternates) {
+		t.Fatalf("expected: %+v\ngot: %+v", expectedAlternates, page.Alternates)
+	}
+	for i, alternate := range page.Alternates {
+		if alternate != expectedAlternates[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expectedAlternates[i], alternate)
+		}
+	}
+
+	expectedHeadings := []Heading{
+		{Level: 1, Text: "All the posts"},
+		{Level: 2, Text: "First"},
+		{Level: 3, Text: "Nested"},
+		{Level: 2, Text: "Second"},
+		{Level: 3, Text: "Unclosed"},
+	}
+	if len(page.Headings) != len(expectedHeadings) {
+		t.Fatalf("expected: %+v\ngot: %+v", expectedHeadings, page.Headings)
+	}
+	for i, heading := range page.Headings {
+		if heading != expectedHeadings[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expectedHeadings[i], heading)
+		}
+	}
+
+	// the title, styles, scripts and noscript fallback aren't counted
+	if page.WordCount != 10 {
+		t.Errorf("expected: %+v\ngot: %+v", 10, page.WordCount)
+	}
+}
@@ -2,24 +2,20 @@ package parser
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/integralist/go-web-crawler/internal/instrumentator"
 	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/robots"
 	"golang.org/x/net/html"
 )
 
-const defaultWorkerPool = 20
-
 // protocol is the scheme the user has specified (HTTPS or HTTP)
 var protocol string
 
-// hostname is the top-level host the user has specified (minus the subdomain)
-var hostname string
-
 // ValidHosts is a map of valid URLs that are then used for inspecting the
 // returned HTML from a HTTP GET request, and is set by the main package.
 var ValidHosts map[string]bool
@@ -28,17 +24,81 @@ var ValidHosts map[string]bool
 type Assets []html.Token
 
 // Page represents the tokenized elements of a HTML page.
+//
+// External holds the (resolved) URLs of any anchors, links or scripts that
+// point to a host we don't crawl, so that they can still be checked.
+//
+// Canonical is the (resolved) URL from a <link rel="canonical"> element, and
+// NoIndex indicates the page asked not to be indexed (via either a robots
+// <meta> element or an X-Robots-Tag header) just as NoFollow indicates the page
+// asked for none of its links to be followed. XRobotsTag holds the directives
+// of any X-Robots-Tag headers that apply to us, and NoFollowURLs holds the
+// anchors that are only ever linked to with rel="nofollow".
+//
+// ContentType is the media type of the response (and Size its length in bytes,
+// when known), and Title is the text of the page's <title> element.
+//
+// Description is the content of the page's description <meta> element,
+// MetaRobots holds the directives of any robots <meta> elements that apply to
+// us (e.g. `noindex, nofollow`), Lang is the language of the <html> element
+// and Alternates are the translations of the page (from its hreflang links).
+// Headings is the page's outline (every <h1>–<h6> in document order) and
+// WordCount is the number of words of visible text.
+//
+// Images, Media (video, audio and their sources/tracks), Iframes, Objects (the
+// <object> and <embed> elements) and Forms (the form actions) hold the rest of
+// the resources a page depends on. Unlike the anchors, links and scripts these
+// tokens only have a single src attribute (holding the resolved URL) as a
+// single element can reference multiple resources (e.g. via srcset).
+//
+// CSSAssets holds the resources referenced by the page's inline CSS (i.e. the
+// url() and @import references within a <style> element or a style attribute)
+// while Stylesheets holds the URLs of the page's <link rel="stylesheet">
+// elements (and of the stylesheets its <style> elements @import) so the
+// coordinator can scan them for the resources they reference.
+//
+// Hints holds the (resolved) URLs of the page's resource hints (e.g. a <link
+// rel="preconnect">), which are still part of the links (or external URLs) but
+// typically aren't a resource that can be requested.
 type Page struct {
-	Anchors Assets
-	Links   Assets
-	Scripts Assets
-	URL     string
+	Anchors      Assets
+	Links        Assets
+	Scripts      Assets
+	Images       Assets
+	Media        Assets
+	Iframes      Assets
+	Objects      Assets
+	Forms        Assets
+	CSSAssets    Assets
+	Stylesheets  []string
+	External     []string
+	Hints        []string
+	URL          string
+	Status       int
+	ContentType  string
+	Size         int64
+	Title        string
+	Description  string
+	MetaRobots   string
+	Lang         string
+	Alternates   []Alternate
+	Headings     []Heading
+	WordCount    int
+	LastModified string
+	Canonical    string
+	NoIndex      bool
+	NoFollow     bool
+	XRobotsTag   string
+	NoFollowURLs []string
+	Redirects    []requester.Redirect
+	RedirectLoop bool
+	Error        string
+	Blocked      bool
 }
 
 // Init configures the package from an outside mediator
 func Init(p, h, s string) {
 	protocol = p
-	hostname = h
 	setValidHosts(h, s)
 }
 
@@ -72,10 +132,100 @@ func setValidHosts(hostname string, subdomains string) {
 
 // Parse accepts a read http.Request body and tokenizes it. It will construct a
 // page struct consisting of the anchors, links and scripts for the given page.
+//
+// Pages that couldn't be requested (even after retrying), or that responded
+// with a non 200 status, have nothing to tokenize but we still return them so
+// the failure can be reported.
 func Parse(page requester.Page, instr *instrumentator.Instr) Page {
+	if page.Err == nil && page.Status != 200 {
+		return Page{
+			URL:         page.URL,
+			Status:      page.Status,
+			ContentType: contentType(page),
+			Redirects:   page.Redirects,
+		}
+	}
+
+	if page.Err != nil {
+		return Page{
+			URL:          page.URL,
+			Redirects:    page.Redirects,
+			RedirectLoop: errors.Is(page.Err, requester.ErrRedirectLoop),
+			Error:        page.Err.Error(),
+			Blocked:      errors.Is(page.Err, robots.ErrBlocked),
+		}
+	}
+
+	// the X-Robots-Tag header is the only way for a resource that isn't a HTML
+	// document (e.g. a PDF) to ask not to be indexed.
+	var noIndex bool
+	var noFollow bool
+	xRobotsTag := headerRobots(page.Header)
+	for _, directives := range xRobotsTag {
+		noIndex = noIndex || noIndexDirective(directives)
+		noFollow = noFollow || noFollowDirective(directives)
+	}
+
+	// only a HTML document is tokenized, anything else that was linked to (e.g.
+	// an image or a PDF) is a leaf node that's recorded along with its media type
+	// and size.
+	mediaType := contentType(page)
+	if !requester.IsHTML(mediaType) {
+		return Page{
+			URL:          page.URL,
+			Status:       page.Status,
+			ContentType:  mediaType,
+			Size:         size(page),
+			LastModified: LastModified(page.Header),
+			NoIndex:      noIndex,
+			NoFollow:     noFollow,
+			XRobotsTag:   strings.Join(xRobotsTag, ", "),
+			Redirects:    page.Redirects,
+		}
+	}
+
 	var anchors []html.Token
 	var links []html.Token
 	var scripts []html.Token
+	var external []string
+	assets := map[int]Assets{}
+	var canonicalURL string
+	var baseSet bool
+	var title string
+	var inTitle bool
+	var inStyle bool
+	var stylesheets []string
+	var hints []string
+	var description string
+	var metaRobotsDirectives []string
+	var lang string
+	var alternates []Alternate
+	var headings []Heading
+	var words int
+
+	// note: the same URL can be linked to both with and without nofollow, in
+	// which case it's still followed.
+	followed := map[string]bool{}
+	var noFollowed []string
+
+	// hidden indicates we're within an element whose text isn't rendered (e.g.
+	// a <script>), and heading is the index of the heading we're within (if any).
+	var hidden bool
+	heading := -1
+
+	// relative URLs are resolved against the page URL (i.e. the URL the page
+	// was served from after following any redirects), unless the page specifies
+	// a different base URL using a <base href="..."> element.
+	pageURL := page.FinalURL
+	if pageURL == "" {
+		pageURL = page.URL
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		instr.Logger.Debug("URL_INVALID")
+		return Page{URL: page.URL, Status: page.Status, Redirects: page.Redirects}
+	}
 
 	r := bytes.NewReader(page.Body)
 	tz := html.NewTokenizer(r)
@@ -87,94 +237,206 @@ func Parse(page requester.Page, instr *instrumentator.Instr) Page {
 		case tt == html.ErrorToken:
 			instr.Logger.Debug("PARSER_EOF")
 
+			for i := range headings {
+				headings[i].Text = collapse(headings[i].Text)
+			}
+
+			var noFollowURLs []string
+			for _, url := range noFollowed {
+				if !followed[url] {
+					followed[url] = true
+					noFollowURLs = append(noFollowURLs, url)
+				}
+			}
+
 			return Page{
-				URL:     page.URL,
-				Anchors: anchors,
-				Links:   links,
-				Scripts: scripts,
+				URL:          page.URL,
+				Status:       page.Status,
+				ContentType:  mediaType,
+				Size:         size(page),
+				Title:        collapse(title),
+				Description:  description,
+				MetaRobots:   strings.Join(metaRobotsDirectives, ", "),
+				Lang:         lang,
+				Alternates:   alternates,
+				Headings:     headings,
+				WordCount:    words,
+				LastModified: LastModified(page.Header),
+				Canonical:    canonicalURL,
+				NoIndex:      noIndex,
+				NoFollow:     noFollow,
+				XRobotsTag:   strings.Join(xRobotsTag, ", "),
+				NoFollowURLs: noFollowURLs,
+				Redirects:    page.Redirects,
+				Anchors:      anchors,
+				Links:        links,
+				Scripts:      scripts,
+				Images:       assets[images],
+				Media:        assets[media],
+				Iframes:      assets[iframes],
+				Objects:      assets[objects],
+				Forms:        assets[forms],
+				CSSAssets:    assets[cssAssets],
+				Stylesheets:  stylesheets,
+				External:     external,
+				Hints:        hints,
+			}
+		case tt == html.TextToken && inTitle:
+			title += string(tz.Text())
+		case tt == html.TextToken && inStyle:
+			// note: a stylesheet imported by a <style> element is scanned just like a
+			// <link rel="stylesheet"> element's stylesheet.
+			tokens, imports, ext := inlineCSSTokens(string(tz.Text()), base)
+			assets[cssAssets] = append(assets[cssAssets], tokens...)
+			stylesheets = append(stylesheets, imports...)
+			external = append(external, ext...)
+		case tt == html.TextToken && !hidden:
+			text := string(tz.Text())
+			words += len(strings.Fields(text))
+			if heading >= 0 {
+				headings[heading].Text += text
+			}
+		case tt == html.EndTagToken:
+			inTitle = false
+			inStyle = false
+			hidden = false
+
+			// note: a browser closes a heading with the end tag of any heading.
+			if name, _ := tz.TagName(); headingLevels[string(name)] > 0 {
+				heading = -1
 			}
-		case tt == html.StartTagToken:
+		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
 			t := tz.Token()
 
+			// the tokenizer returns the contents of these elements as a single text
+			// token, meaning the next end tag is always the element's own.
+			hidden = tt == html.StartTagToken && hiddenText[t.Data]
+
+			// any element can have inline CSS referencing resources (e.g. a
+			// background image) regardless of what else it references.
+			if style := attribute(t.Attr, "style"); style != "" {
+				tokens, _, ext := inlineCSSTokens(style, base)
+				assets[cssAssets] = append(assets[cssAssets], tokens...)
+				external = append(external, ext...)
+			}
+
+			if level, ok := headingLevels[t.Data]; ok {
+				headings = append(headings, Heading{Level: level})
+				heading = len(headings) - 1
+				continue
+			}
+
+			if t.Data == "html" && lang == "" {
+				lang = strings.TrimSpace(attribute(t.Attr, "lang"))
+				continue
+			}
+
+			if t.Data == "style" && tt == html.StartTagToken {
+				inStyle = true
+				continue
+			}
+
+			// note: only the first <title> counts, as an <svg> element can contain
+			// its own <title> elements.
+			if t.Data == "title" && tt == html.StartTagToken && title == "" {
+				inTitle = true
+				continue
+			}
+
+			// note: only the first <base> element with a href counts (as per the
+			// HTML spec), any others are ignored.
+			if t.Data == "base" {
+				if !baseSet {
+					base, baseSet = baseURL(base, t.Attr)
+				}
+				continue
+			}
+
 			isAnchor := t.Data == "a"
 			isLink := t.Data == "link"
 			isScript := t.Data == "script"
 
+			if isLink {
+				if alternate, ok := hreflangAlternate(t.Attr, base); ok {
+					alternates = append(alternates, alternate)
+				}
+			}
+
 			if isLink && canonical(t.Attr) {
+				canonicalURL = canonicalHref(t.Attr, base)
 				continue
 			}
 
-			if (isAnchor || isLink) && excludeInvalidURLs(&t, "href", instr) {
+			if t.Data == "meta" {
+				if directives, ok := metaRobots(t.Attr); ok {
+					metaRobotsDirectives = append(metaRobotsDirectives, directives)
+					noIndex = noIndex || noIndexDirective(directives)
+					noFollow = noFollow || noFollowDirective(directives)
+				}
+
+				// note: only the first description counts (just as with the title).
+				if name, content := metaName(t.Attr); name == "description" && description == "" {
+					description = collapse(content)
+				}
 				continue
 			}
 
-			if isScript && (missingScriptSrc(t.Attr) || excludeInvalidURLs(&t, "src", instr)) {
+			if _, ok := assetElements[t.Data]; ok {
+				tokens, ext := assetTokens(t, base)
+				for class, classTokens := range tokens {
+					assets[class] = append(assets[class], classTokens...)
+				}
+				external = append(external, ext...)
 				continue
 			}
 
-			if isAnchor {
-				anchors = append(anchors, t)
-			}
+			if (isAnchor || isLink) && excludeInvalidURLs(&t, "href", base, instr) {
+				if u, ok := externalURL(t, "href", base); ok {
+					external = append(external, u)
 
-			if isLink {
-				links = append(links, t)
+					if isLink && resourceHint(t.Attr) {
+						hints = append(hints, u)
+					}
+				}
+				continue
 			}
 
-			if isScript {
-				scripts = append(scripts, t)
+			if isScript && missingScriptSrc(t.Attr) {
+				continue
 			}
-		}
-	}
-}
 
-// ParseCollection concurrently parses a slice of requester.Page
-func ParseCollection(pages []requester.Page, instr *instrumentator.Instr) []Page {
-	var mutex = &sync.Mutex{}
-	var wg sync.WaitGroup
-	var tokenizedPages []Page
-
-	// dynamically determine the worker pool size, we'll either set a default or
-	// use a smaller value if the number of tasks is smaller than the default.
-	toProcess := len(pages)
-	workerPool := defaultWorkerPool
-	if toProcess < defaultWorkerPool {
-		workerPool = toProcess
-	}
+			if isScript && excludeInvalidURLs(&t, "src", base, instr) {
+				if u, ok := externalURL(t, "src", base); ok {
+					external = append(external, u)
+				}
+				continue
+			}
 
-	startTime := time.Now()
-	tasks := make(chan requester.Page, workerPool)
+			if isAnchor {
+				anchors = append(anchors, t)
 
-	// spin up our worker pool as goroutines awaiting tasks to be processed
-	for i := 0; i < workerPool; i++ {
-		wg.Add(1)
+				if href := attribute(t.Attr, "href"); hasRel(t.Attr, "nofollow") {
+					noFollowed = append(noFollowed, href)
+				} else {
+					followed[href] = true
+				}
+			}
 
-		go func(i int) {
-			defer wg.Done()
+			if isLink {
+				links = append(links, t)
 
-			for page := range tasks {
-				tokenizedPage := Parse(page, instr)
+				if href, ok := stylesheetHref(t.Attr); ok {
+					stylesheets = append(stylesheets, href)
+				}
 
-				// we use a mutex to ensure thread safety, not only for the correctness
-				// of the program but also because the Go language can trigger a panic!
-				mutex.Lock()
-				tokenizedPages = append(tokenizedPages, tokenizedPage)
-				mutex.Unlock()
+				if resourceHint(t.Attr) {
+					hints = append(hints, attribute(t.Attr, "href"))
+				}
 			}
-		}(i)
-	}
 
-	for _, page := range pages {
-		if page.Status != 200 {
-			instr.Logger.Debug("non 200 page:", page.URL)
-			continue
+			if isScript {
+				scripts = append(scripts, t)
+			}
 		}
-		tasks <- page
 	}
-
-	close(tasks)
-
-	wg.Wait()
-	instr.Logger.Debug("time spent parsing:", time.Since(startTime))
-
-	return tokenizedPages
 }
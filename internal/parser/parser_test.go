@@ -0,0 +1,393 @@
+package parser
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+// anchors parses the given body as if it were served from pageURL (after being
+// redirected from requestedURL) and returns the resolved anchor hrefs.
+func anchors(requestedURL, pageURL, body string) []string {
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL:      requestedURL,
+		FinalURL: pageURL,
+		Body:     []byte(body),
+		Status:   200,
+	}, &instr)
+
+	var hrefs []string
+	for _, a := range page.Anchors {
+		for _, attr := range a.Attr {
+			if attr.Key == "href" {
+				hrefs = append(hrefs, attr.Val)
+			}
+		}
+	}
+	return hrefs
+}
+
+func TestParseResolvesRelativeURLs(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	for _, tc := range []struct {
+		name     string
+		pageURL  string
+		href     string
+		expected string
+	}{
+		{"parent directory", "http://www.example.com/posts/foo/", "../about", "http://www.example.com/posts/about"},
+		{"sibling document", "http://www.example.com/posts/foo/", "next.html", "http://www.example.com/posts/foo/next.html"},
+		{"sibling of a document", "http://www.example.com/posts/foo.html", "bar.html", "http://www.example.com/posts/bar.html"},
+		{"current directory", "http://www.example.com/posts/foo/", "./bar", "http://www.example.com/posts/foo/bar"},
+		{"absolute path", "http://www.example.com/posts/foo/", "/about", "http://www.example.com/about"},
+		{"above the root", "http://www.example.com/posts/foo/", "../../../../about", "http://www.example.com/about"},
+		{"dot segments in absolute path", "http://www.example.com/", "/a/./b/../c", "http://www.example.com/a/c"},
+		{"protocol relative", "http://www.example.com/posts/", "//www.example.com/about", "http://www.example.com/about"},
+		{"absolute url", "http://www.example.com/posts/", "http://www.example.com/about#team", "http://www.example.com/about"},
+		{"uppercase host", "http://www.example.com/posts/", "HTTP://WWW.EXAMPLE.COM/about", "http://www.example.com/about"},
+		{"query only", "http://www.example.com/posts/foo/", "?page=2", "http://www.example.com/posts/foo/?page=2"},
+		{"fragment only", "http://www.example.com/posts/foo/", "#comments", "http://www.example.com/posts/foo/"},
+		{"empty href", "http://www.example.com/posts/foo/", "", "http://www.example.com/posts/foo/"},
+		{"surrounding whitespace", "http://www.example.com/posts/", "  bar/ \n", "http://www.example.com/posts/bar/"},
+		{"embedded newline", "http://www.example.com/posts/", "ba\nr", "http://www.example.com/posts/bar"},
+		{"encoded characters", "http://www.example.com/posts/", "caf%C3%A9", "http://www.example.com/posts/caf%C3%A9"},
+		{"default port", "http://www.example.com:80/posts/", "bar", "http://www.example.com/posts/bar"},
+	} {
+		body := fmt.Sprintf(`<a href="%s">link</a>`, tc.href)
+		actual := anchors(tc.pageURL, tc.pageURL, body)
+
+		if len(actual) != 1 || actual[0] != tc.expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseExcludesUnsupportedURLs(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	for _, href := range []string{
+		"mailto:someone@example.com",
+		"tel:+441234567890",
+		"javascript:void(0)",
+		"data:text/html,hello",
+		"http://www.other.com/",
+		"//cdn.other.com/lib.js",
+		"http://[::1",
+	} {
+		body := fmt.Sprintf(`<a href="%s">link</a>`, href)
+
+		if actual := anchors("http://www.example.com/", "http://www.example.com/", body); len(actual) != 0 {
+			t.Errorf("%s expected no anchors\ngot: %+v", href, actual)
+		}
+	}
+}
+
+func TestParseResolvesAgainstRedirectedURL(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	// the request for /posts was redirected to /posts/ and so relative links
+	// need to be resolved against the latter.
+	actual := anchors("http://www.example.com/posts", "http://www.example.com/posts/", `<a href="foo/">foo</a>`)
+	expected := "http://www.example.com/posts/foo/"
+
+	if len(actual) != 1 || actual[0] != expected {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+	}
+}
+
+func TestParseResolvesAgainstBaseHref(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	body := `<html>
+	<head><base href="/guide/v2/"></head>
+	<body>
+		<a href="intro">intro</a>
+		<a href="../v1/">v1</a>
+		<a href="/about">about</a>
+	</body>
+</html>`
+
+	actual := anchors("http://www.example.com/", "http://www.example.com/", body)
+	expected := []string{
+		"http://www.example.com/guide/v2/intro",
+		"http://www.example.com/guide/v1/",
+		"http://www.example.com/about",
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, actual)
+	}
+
+	for i, v := range actual {
+		if v != expected[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], v)
+		}
+	}
+}
+
+func TestParseUsesFirstBaseHref(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	// a <base> without a href doesn't count, and a second <base href> is ignored.
+	body := `<html>
+	<head>
+		<base target="_blank">
+		<base href="/guide/v2/">
+		<base href="/other/">
+	</head>
+	<body>
+		<a href="intro">intro</a>
+	</body>
+</html>`
+
+	actual := anchors("http://www.example.com/", "http://www.example.com/", body)
+	expected := []string{"http://www.example.com/guide/v2/intro"}
+
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+	}
+}
+
+func TestParseResolvesScriptsAndLinks(t *testing.T) {
+	Init("https", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL:    "https://www.example.com/posts/foo/",
+		Body:   []byte(`<link rel="stylesheet" href="../../css/main.css" /><script src="app.js"></script>`),
+		Status: 200,
+	}, &instr)
+
+	if len(page.Links) != 1 || page.Links[0].Attr[1].Val != "https://www.example.com/css/main.css" {
+		t.Errorf("expected: %+v\ngot: %+v", "https://www.example.com/css/main.css", page.Links)
+	}
+
+	if len(page.Scripts) != 1 || page.Scripts[0].Attr[0].Val != "https://www.example.com/posts/foo/app.js" {
+		t.Errorf("expected: %+v\ngot: %+v", "https://www.example.com/posts/foo/app.js", page.Scripts)
+	}
+}
+
+func TestParseCollectsExternalURLs(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL: "http://www.example.com/",
+		Body: []byte(`<a href="https://www.other.com/foo">foo</a>
+<a href="mailto:someone@example.com">mail</a>
+<a href="/bar">bar</a>
+<link rel="stylesheet" href="//cdn.other.com/main.css">
+<script src="https://cdn.other.com/app.js"></script>`),
+		Status: 200,
+	}, &instr)
+
+	expected := []string{
+		"https://www.other.com/foo",
+		"http://cdn.other.com/main.css",
+		"https://cdn.other.com/app.js",
+	}
+
+	if len(page.External) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, page.External)
+	}
+
+	for i, v := range page.External {
+		if v != expected[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], v)
+		}
+	}
+
+	if len(page.Anchors) != 1 {
+		t.Errorf("expected: %+v\ngot: %+v", 1, len(page.Anchors))
+	}
+}
+
+func TestParseResourceHints(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL: "http://www.example.com/",
+		Body: []byte(`<link rel="preconnect" href="https://fonts.other.com">
+<link rel="dns-prefetch" href="//cdn.other.com">
+<link rel="preload" href="/fonts/a.woff2" as="font">
+<link rel="stylesheet" href="/main.css">`),
+		Status: 200,
+	}, &instr)
+
+	// the hints are still part of the links and external URLs.
+	if len(page.Links) != 2 || len(page.External) != 2 {
+		t.Errorf("expected: %+v\ngot: %+v", "2 links and 2 external URLs", page)
+	}
+
+	expected := []string{
+		"https://fonts.other.com/",
+		"http://cdn.other.com/",
+		"http://www.example.com/fonts/a.woff2",
+	}
+
+	if fmt.Sprint(page.Hints) != fmt.Sprint(expected) {
+		t.Errorf("expected: %+v\ngot: %+v", expected, page.Hints)
+	}
+}
+
+func TestParseNon200(t *testing.T) {
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	page := Parse(requester.Page{
+		URL:    "http://www.example.com/missing",
+		Body:   []byte(`<a href="/foo">foo</a>`),
+		Status: 404,
+	}, &instr)
+
+	if page.Status != 404 {
+		t.Errorf("expected: %+v\ngot: %+v", 404, page.Status)
+	}
+
+	if len(page.Anchors) != 0 {
+		t.Errorf("expected no anchors\ngot: %+v", page.Anchors)
+	}
+}
+
+func TestParseTitleAndContentType(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+
+	page := Parse(requester.Page{
+		URL:    "http://www.example.com/",
+		Header: header,
+		Body: []byte(`<html><head><title>
+			Foo &amp; Bar
+		</title></head><body><svg><title>icon</title></svg></body></html>`),
+		Status: 200,
+	}, &instr)
+
+	if page.Title != "Foo & Bar" {
+		t.Errorf("expected: %+v\ngot: %+v", "Foo & Bar", page.Title)
+	}
+
+	if page.ContentType != "text/html" {
+		t.Errorf("expected: %+v\ngot: %+v", "text/html", page.ContentType)
+	}
+}
+
+func TestParseNonHTML(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	// a resource is a leaf node, even if its body happens to look like HTML
+	page := Parse(requester.Page{
+		URL:         "http://www.example.com/notes.txt",
+		ContentType: "text/plain",
+		Size:        22,
+		Body:        []byte(`<a href="/foo">foo</a>`),
+		Status:      200,
+	}, &instr)
+
+	if page.ContentType != "text/plain" || page.Size != 22 {
+		t.Errorf("expected: %+v %+v\ngot: %+v %+v", "text/plain", 22, page.ContentType, page.Size)
+	}
+
+	if len(page.Anchors) != 0 {
+		t.Errorf("expected no anchors\ngot: %+v", page.Anchors)
+	}
+}
+
+func TestParseRobotsDirectives(t *testing.T) {
+	Init("http", "example.com", "www")
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	// a directive meant for another crawler is ignored
+	header := http.Header{}
+	header.Add("X-Robots-Tag", "noarchive")
+	header.Add("X-Robots-Tag", "googlebot: nofollow")
+	header.Add("X-Robots-Tag", "Go-Web-Crawler: noindex")
+
+	page := Parse(requester.Page{
+		URL:    "http://www.example.com/",
+		Header: header,
+		Body: []byte(`
+			<a href="/sponsored" rel="sponsored nofollow">sponsored</a>
+			<a href="/login" rel="NoFollow">login</a>
+			<a href="/about" rel="nofollow">about</a>
+			<a href="/about">about</a>
+			<a href="/contact">contact</a>
+		`),
+		Status: 200,
+	}, &instr)
+
+	if page.XRobotsTag != "noarchive, noindex" {
+		t.Errorf("expected: %+v\ngot: %+v", "noarchive, noindex", page.XRobotsTag)
+	}
+
+	if !page.NoIndex || page.NoFollow {
+		t.Errorf("expected: %+v %+v\ngot: %+v %+v", true, false, page.NoIndex, page.NoFollow)
+	}
+
+	// a URL that's also linked to without nofollow is still followed
+	expected := []string{"http://www.example.com/sponsored", "http://www.example.com/login"}
+	if fmt.Sprint(page.NoFollowURLs) != fmt.Sprint(expected) {
+		t.Errorf("expected: %+v\ngot: %+v", expected, page.NoFollowURLs)
+	}
+
+	if len(page.Anchors) != 5 {
+		t.Errorf("expected: %+v\ngot: %+v", 5, len(page.Anchors))
+	}
+
+	page = Parse(requester.Page{
+		URL:    "http://www.example.com/",
+		Body:   []byte(`<meta name="robots" content="none"><a href="/about">about</a>`),
+		Status: 200,
+	}, &instr)
+
+	if !page.NoIndex || !page.NoFollow {
+		t.Errorf("expected: %+v %+v\ngot: %+v %+v", true, true, page.NoIndex, page.NoFollow)
+	}
+
+	// a resource that isn't a HTML document can only use the header
+	header = http.Header{}
+	header.Set("X-Robots-Tag", "noindex, nofollow")
+
+	page = Parse(requester.Page{
+		URL:         "http://www.example.com/report.pdf",
+		Header:      header,
+		ContentType: "application/pdf",
+		Status:      200,
+	}, &instr)
+
+	if !page.NoIndex || !page.NoFollow || page.XRobotsTag != "noindex, nofollow" {
+		t.Errorf("expected: %+v %+v %+v\ngot: %+v %+v %+v", true, true, "noindex, nofollow", page.NoIndex, page.NoFollow, page.XRobotsTag)
+	}
+}
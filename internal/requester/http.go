@@ -1,38 +1,407 @@
 package requester
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// UserAgent is the User-Agent header sent with every request (and so it's also
+// the product token used to select our rules from a robots.txt).
+const UserAgent = "go-web-crawler"
+
 // HTTPClient is an interface for injecting a preconfigured HTTP client.
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
 }
 
 // Page represents the requested HTML page (its url & body).
+//
+// FinalURL is the URL the response was actually served from, which differs
+// from URL when the request was redirected (each hop being recorded in
+// Redirects).
+//
+// ContentType is the media type of the response (sniffed from the start of the
+// body when the server doesn't send a Content-Type header) and Size is its
+// length in bytes (or -1 when unknown, e.g. a HEAD request to a server that
+// doesn't send a Content-Length header).
 type Page struct {
-	URL    string
-	Body   []byte
-	Status int
+	URL         string
+	FinalURL    string
+	Redirects   []Redirect
+	Header      http.Header
+	Body        []byte
+	ContentType string
+	Size        int64
+	Status      int
+	Attempts    int
+	Err         error
+}
+
+// Validators are the values from a previous response that allow a request to
+// be made conditional (i.e. the server only responds with the page if it has
+// changed, and otherwise responds with a 304 Not Modified).
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// sniffLength is the number of bytes http.DetectContentType considers.
+const sniffLength = 512
+
+// IsHTML reports whether a media type is a HTML document (an empty media type
+// is given the benefit of the doubt, as that's what we'd previously assumed).
+func IsHTML(mediaType string) bool {
+	switch mediaType {
+	case "", "text/html", "application/xhtml+xml":
+		return true
+	}
+	return false
+}
+
+// Redirect represents a single hop in a chain of redirects.
+type Redirect struct {
+	URL      string
+	Status   int
+	Location string
+}
+
+// ErrRedirectLoop is returned when a redirect leads back to a URL that was
+// already visited as part of the same chain of redirects.
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// ErrTooManyRedirects is returned when a chain of redirects is longer than the
+// maximum number of redirects allowed.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// DefaultMaxRedirects matches the number of redirects the net/http client
+// follows by default.
+const DefaultMaxRedirects = 10
+
+// CheckRedirect returns a function for use as http.Client.CheckRedirect, which
+// follows at most max redirects and stops as soon as a redirect loop is found.
+func CheckRedirect(max int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		for _, r := range via {
+			if r.URL.String() == req.URL.String() {
+				return ErrRedirectLoop
+			}
+		}
+
+		if len(via) > max {
+			return ErrTooManyRedirects
+		}
+
+		return nil
+	}
+}
+
+// RetryPolicy describes how many times (and how patiently) a failed request
+// should be retried before we give up on it.
+type RetryPolicy struct {
+	MaxAttempts     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	Jitter          float64
+	RetryableStatus map[int]bool
+}
+
+// RetryError is returned when a URL could not be successfully requested within
+// the number of attempts allowed by the configured RetryPolicy.
+type RetryError struct {
+	URL      string
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("giving up on %s after %d attempt(s): %s", e.URL, e.Attempts, e.Err)
+}
+
+// DefaultRetryPolicy is used when the package hasn't been configured via Init.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Jitter:      0.5,
+	RetryableStatus: map[int]bool{
+		http.StatusTooManyRequests:    true,
+		http.StatusBadGateway:         true,
+		http.StatusServiceUnavailable: true,
+		http.StatusGatewayTimeout:     true,
+	},
+}
+
+// policy is the retry policy applied to every call to Get.
+var policy = DefaultRetryPolicy
+
+// sleep is a package level variable so tests can avoid real delays.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Init configures the package from an outside mediator
+func Init(p RetryPolicy) {
+	policy = p
 }
 
 // Get retrieves the contents of the specified url parameter.
-func Get(url string, client HTTPClient) (Page, error) {
-	res, err := client.Get(url)
+//
+// Network errors and responses with a retryable status code are retried using
+// exponential backoff (with jitter), and a Retry-After header sent by the
+// server takes precedence over our own calculated delay.
+//
+// The given context is attached to every request attempt, so cancelling it will
+// abort both an in-flight request and any pending retry.
+func Get(ctx context.Context, url string, client HTTPClient) (Page, error) {
+	return request(ctx, http.MethodGet, url, Validators{}, false, client)
+}
+
+// GetConditional is the same as Get but sends If-None-Match/If-Modified-Since
+// headers based on the given validators, meaning an unchanged page results in
+// a 304 status (and no body) rather than the page being downloaded again.
+func GetConditional(ctx context.Context, url string, v Validators, client HTTPClient) (Page, error) {
+	return request(ctx, http.MethodGet, url, v, false, client)
+}
+
+// GetHTML is the same as GetConditional but only downloads the body of a HTML
+// document. The response's media type is determined by its Content-Type header
+// (or by sniffing the first chunk of the body when there isn't one) and for
+// anything else (e.g. a PDF or a video) the connection is closed once we know
+// what it is, leaving the returned page with a ContentType and Size but no
+// body.
+func GetHTML(ctx context.Context, url string, v Validators, client HTTPClient) (Page, error) {
+	return request(ctx, http.MethodGet, url, v, true, client)
+}
+
+// Head is the same as Get but makes a HEAD request (meaning the returned page
+// has no body), which is a cheaper way of checking whether a URL is reachable.
+func Head(ctx context.Context, url string, client HTTPClient) (Page, error) {
+	return request(ctx, http.MethodHead, url, Validators{}, false, client)
+}
+
+// request retries the given request as per the package's RetryPolicy.
+func request(ctx context.Context, method, url string, v Validators, htmlOnly bool, client HTTPClient) (Page, error) {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var page Page
+	var retryAfter time.Duration
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		page, retryAfter, err = do(ctx, method, url, v, htmlOnly, client)
+		page.Attempts = attempt
+
+		if err == nil && !policy.RetryableStatus[page.Status] {
+			return page, nil
+		}
+
+		// a redirect loop isn't going to fix itself, so there's no point retrying
+		if errors.Is(err, ErrRedirectLoop) || errors.Is(err, ErrTooManyRedirects) {
+			return page, err
+		}
+
+		// there's no point retrying a request that was cancelled
+		if ctx.Err() != nil {
+			return page, ctx.Err()
+		}
+
+		if attempt < attempts {
+			if err := sleep(ctx, policy.delay(attempt, retryAfter)); err != nil {
+				return page, err
+			}
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("unexpected status code %d", page.Status)
+	}
+
+	return page, &RetryError{URL: url, Attempts: page.Attempts, Err: err}
+}
+
+// do makes a single request attempt.
+func do(ctx context.Context, method, url string, v Validators, htmlOnly bool, client HTTPClient) (Page, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
-		return Page{}, err
+		return Page{URL: url}, 0, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	res.Body.Close()
+	req.Header.Set("User-Agent", UserAgent)
+
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+
+	res, err := client.Do(req)
 	if err != nil {
-		return Page{}, err
+		// when a redirect is refused (e.g. a loop was detected) the client still
+		// provides the last response, which lets us record the chain of redirects
+		// that led to the failure.
+		page := Page{URL: url}
+		if res != nil {
+			page.Redirects = append(redirects(res), hop(res))
+			page.Status = res.StatusCode
+		}
+		return page, 0, err
+	}
+
+	// note: the first chunk is peeked (rather than read) so that the body can
+	// still be read in full when it turns out to be a HTML document, and a server
+	// that doesn't know what it's serving (i.e. application/octet-stream) is no
+	// better than one that doesn't say.
+	r := bufio.NewReaderSize(res.Body, sniffLength)
+	mediaType := headerMediaType(res.Header)
+	if (mediaType == "" || mediaType == "application/octet-stream") && method != http.MethodHead {
+		chunk, _ := r.Peek(sniffLength)
+		if len(chunk) > 0 {
+			mediaType = headerMediaType(http.Header{"Content-Type": {http.DetectContentType(chunk)}})
+		}
+	}
+
+	var body []byte
+	size := res.ContentLength
+	if !htmlOnly || IsHTML(mediaType) {
+		body, err = ioutil.ReadAll(r)
+		if err != nil {
+			res.Body.Close()
+			return Page{URL: url, Status: res.StatusCode}, 0, err
+		}
+		if method != http.MethodHead {
+			size = int64(len(body))
+		}
+	}
+	res.Body.Close()
+
+	finalURL := url
+	if res.Request != nil && res.Request.URL != nil {
+		finalURL = res.Request.URL.String()
 	}
 
 	return Page{
-		URL:    url,
-		Body:   body,
-		Status: res.StatusCode,
-	}, nil
+		URL:         url,
+		FinalURL:    finalURL,
+		Redirects:   redirects(res),
+		Header:      res.Header,
+		Body:        body,
+		ContentType: mediaType,
+		Size:        size,
+		Status:      res.StatusCode,
+	}, retryAfter(res.Header.Get("Retry-After")), nil
+}
+
+// headerMediaType returns the media type from a Content-Type header, without
+// any parameters (e.g. `text/html; charset=utf-8` becomes `text/html`).
+func headerMediaType(header http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// redirects returns the chain of redirects that led to the given response.
+//
+// note: the net/http client sets Request.Response to the redirect response
+// that caused the request to be made, which means we can walk backwards
+// through the chain of redirects from the final response.
+func redirects(res *http.Response) []Redirect {
+	var chain []Redirect
+
+	if res.Request == nil {
+		return chain
+	}
+
+	for r := res.Request.Response; r != nil; {
+		chain = append([]Redirect{hop(r)}, chain...)
+
+		if r.Request == nil {
+			break
+		}
+		r = r.Request.Response
+	}
+
+	return chain
+}
+
+// hop converts a redirect response into a Redirect.
+func hop(res *http.Response) Redirect {
+	r := Redirect{
+		Status:   res.StatusCode,
+		Location: res.Header.Get("Location"),
+	}
+	if res.Request != nil && res.Request.URL != nil {
+		r.URL = res.Request.URL.String()
+	}
+	return r
+}
+
+// delay calculates how long to wait before the next attempt.
+//
+// the backoff doubles on each attempt (capped at MaxDelay) and the jitter then
+// randomly shaves off up to the given fraction of it, so that concurrent
+// workers don't all retry in lockstep.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	backoff := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && backoff > float64(p.MaxDelay) {
+		backoff = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		backoff -= backoff * p.Jitter * rand.Float64()
+	}
+
+	d := time.Duration(backoff)
+
+	// note: we honour Retry-After but still cap it, as a server asking us to
+	// come back in an hour would otherwise stall the whole crawl.
+	if retryAfter > d {
+		d = retryAfter
+		if p.MaxDelay > 0 && d > p.MaxDelay {
+			d = p.MaxDelay
+		}
+	}
+
+	return d
+}
+
+// retryAfter parses the Retry-After header, which can either be a number of
+// seconds or a HTTP date.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
 }
@@ -2,14 +2,18 @@ package requester
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type MockHTTPClient struct{}
 
-func (mhc *MockHTTPClient) Get(url string) (*http.Response, error) {
+func (mhc *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	body := "foobar"
 
 	return &http.Response{
@@ -29,7 +33,7 @@ func TestGet(t *testing.T) {
 
 	mockHTTPclient := MockHTTPClient{}
 
-	actual, _ := Get(input, &mockHTTPclient)
+	actual, _ := Get(context.Background(), input, &mockHTTPclient)
 
 	if actual.URL != output.URL {
 		t.Errorf("expected: %+v\ngot: %+v", output.URL, actual.URL)
@@ -46,3 +50,267 @@ func TestGet(t *testing.T) {
 		t.Errorf("expected: %+v\ngot: %+v", stringOutputBody, stringActualBody)
 	}
 }
+
+type FlakyHTTPClient struct {
+	statuses []int
+	calls    int
+}
+
+func (fhc *FlakyHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	status := fhc.statuses[fhc.calls]
+	fhc.calls++
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString("foobar")),
+	}, nil
+}
+
+func TestGetRetries(t *testing.T) {
+	defer func(s func(context.Context, time.Duration) error) { sleep = s }(sleep)
+	sleep = func(context.Context, time.Duration) error { return nil }
+
+	flakyHTTPClient := FlakyHTTPClient{statuses: []int{503, 502, 200}}
+
+	actual, err := Get(context.Background(), "http://www.foo.com/bar", &flakyHTTPClient)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actual.Status != 200 {
+		t.Errorf("expected: %+v\ngot: %+v", 200, actual.Status)
+	}
+
+	if actual.Attempts != 3 {
+		t.Errorf("expected: %+v\ngot: %+v", 3, actual.Attempts)
+	}
+}
+
+func TestGetRetriesExhausted(t *testing.T) {
+	defer func(s func(context.Context, time.Duration) error) { sleep = s }(sleep)
+	sleep = func(context.Context, time.Duration) error { return nil }
+
+	flakyHTTPClient := FlakyHTTPClient{statuses: []int{503, 503, 503, 200}}
+
+	_, err := Get(context.Background(), "http://www.foo.com/bar", &flakyHTTPClient)
+
+	retryErr, ok := err.(*RetryError)
+	if !ok {
+		t.Fatalf("expected: *RetryError\ngot: %T", err)
+	}
+
+	if retryErr.Attempts != DefaultRetryPolicy.MaxAttempts {
+		t.Errorf("expected: %+v\ngot: %+v", DefaultRetryPolicy.MaxAttempts, retryErr.Attempts)
+	}
+
+	if flakyHTTPClient.calls != DefaultRetryPolicy.MaxAttempts {
+		t.Errorf("expected: %+v\ngot: %+v", DefaultRetryPolicy.MaxAttempts, flakyHTTPClient.calls)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempt, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+	} {
+		if actual := p.delay(attempt, 0); actual != expected {
+			t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+		}
+	}
+
+	if actual := p.delay(1, 3*time.Second); actual != 3*time.Second {
+		t.Errorf("expected: %+v\ngot: %+v", 3*time.Second, actual)
+	}
+
+	if actual := p.delay(1, time.Minute); actual != 5*time.Second {
+		t.Errorf("expected: %+v\ngot: %+v", 5*time.Second, actual)
+	}
+}
+
+func TestGetCancelled(t *testing.T) {
+	flakyHTTPClient := FlakyHTTPClient{statuses: []int{503, 503, 503}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Get(ctx, "http://www.foo.com/bar", &flakyHTTPClient)
+	if err != context.Canceled {
+		t.Errorf("expected: %+v\ngot: %+v", context.Canceled, err)
+	}
+
+	if flakyHTTPClient.calls != 1 {
+		t.Errorf("expected: %+v\ngot: %+v", 1, flakyHTTPClient.calls)
+	}
+}
+
+func redirectServer(redirects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if location, ok := redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("foobar"))
+	}))
+}
+
+func TestGetRedirects(t *testing.T) {
+	server := redirectServer(map[string]string{"/a": "/b", "/b": "/c"})
+	defer server.Close()
+
+	client := http.Client{CheckRedirect: CheckRedirect(DefaultMaxRedirects)}
+
+	actual, err := Get(context.Background(), server.URL+"/a", &client)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if expected := server.URL + "/c"; actual.FinalURL != expected {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual.FinalURL)
+	}
+
+	expected := []Redirect{
+		{URL: server.URL + "/a", Status: 301, Location: "/b"},
+		{URL: server.URL + "/b", Status: 301, Location: "/c"},
+	}
+
+	if len(actual.Redirects) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, actual.Redirects)
+	}
+
+	for i, hop := range actual.Redirects {
+		if hop != expected[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], hop)
+		}
+	}
+
+	if actual.Header.Get("Content-Type") == "" {
+		t.Errorf("expected: response headers\ngot: %+v", actual.Header)
+	}
+}
+
+func TestGetRedirectLoop(t *testing.T) {
+	server := redirectServer(map[string]string{"/a": "/b", "/b": "/a"})
+	defer server.Close()
+
+	client := http.Client{CheckRedirect: CheckRedirect(DefaultMaxRedirects)}
+
+	actual, err := Get(context.Background(), server.URL+"/a", &client)
+	if !errors.Is(err, ErrRedirectLoop) {
+		t.Fatalf("expected: %+v\ngot: %+v", ErrRedirectLoop, err)
+	}
+
+	if len(actual.Redirects) != 2 {
+		t.Errorf("expected: %+v\ngot: %+v", 2, len(actual.Redirects))
+	}
+
+	// a redirect loop isn't retried
+	if actual.Attempts != 1 {
+		t.Errorf("expected: %+v\ngot: %+v", 1, actual.Attempts)
+	}
+}
+
+func TestGetTooManyRedirects(t *testing.T) {
+	server := redirectServer(map[string]string{"/a": "/b", "/b": "/c"})
+	defer server.Close()
+
+	client := http.Client{CheckRedirect: CheckRedirect(1)}
+
+	_, err := Get(context.Background(), server.URL+"/a", &client)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("expected: %+v\ngot: %+v", ErrTooManyRedirects, err)
+	}
+}
+
+func TestGetUserAgent(t *testing.T) {
+	var actual string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	if _, err := Get(context.Background(), server.URL, http.DefaultClient); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actual != UserAgent {
+		t.Errorf("expected: %+v\ngot: %+v", UserAgent, actual)
+	}
+}
+
+func TestGetConditional(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("foobar"))
+	}))
+	defer server.Close()
+
+	actual, err := GetConditional(context.Background(), server.URL, Validators{}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actual.Status != 200 || actual.Header.Get("ETag") != `"v1"` {
+		t.Errorf("expected: %+v\ngot: %+v %+v", 200, actual.Status, actual.Header)
+	}
+
+	actual, err = GetConditional(context.Background(), server.URL, Validators{ETag: `"v1"`}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actual.Status != 304 {
+		t.Errorf("expected: %+v\ngot: %+v", 304, actual.Status)
+	}
+}
+
+func TestGetHTML(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html></html>"))
+		case "/report":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Length", "2048")
+			w.Write(make([]byte, 2048))
+		case "/photo":
+			// note: without a Content-Type header the net/http server would sniff
+			// one itself, so we explicitly unset it.
+			w.Header()["Content-Type"] = nil
+			w.Write(png)
+		}
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path        string
+		contentType string
+		body        bool
+		size        int64
+	}{
+		{"/page", "text/html", true, 13},
+		{"/report", "application/pdf", false, 2048},
+		{"/photo", "image/png", false, int64(len(png))},
+	} {
+		actual, err := GetHTML(context.Background(), server.URL+tc.path, Validators{}, http.DefaultClient)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if actual.ContentType != tc.contentType || (len(actual.Body) > 0) != tc.body || actual.Size != tc.size {
+			t.Errorf("%s expected: %+v %+v %+v\ngot: %+v %+v %+v", tc.path, tc.contentType, tc.body, tc.size, actual.ContentType, len(actual.Body), actual.Size)
+		}
+	}
+}
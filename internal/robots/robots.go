@@ -0,0 +1,299 @@
+package robots
+
+// The robots package implements the Robots Exclusion Protocol (RFC 9309) so
+// that we can avoid requesting pages a site owner has asked crawlers to skip.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/sirupsen/logrus"
+)
+
+// UserAgent is the product token we look for when selecting a group of rules.
+const UserAgent = requester.UserAgent
+
+// ErrBlocked indicates a URL was not requested because robots.txt disallows it.
+var ErrBlocked = errors.New("blocked by robots")
+
+// rule is a single Allow or Disallow directive.
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+// Rules represents the directives that apply to our user agent for one host.
+//
+// Sitemaps isn't tied to any user agent, and holds the URL of every sitemap
+// listed in the robots.txt.
+type Rules struct {
+	rules      []rule
+	CrawlDelay time.Duration
+	Sitemaps   []string
+}
+
+// Robots maps a host to the rules parsed from its robots.txt
+type Robots struct {
+	hosts map[string]*Rules
+}
+
+// group is a set of user agents and the rules that apply to all of them.
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+// Fetch requests /robots.txt for each of the given hosts.
+//
+// As per RFC 9309, a robots.txt that doesn't exist (4xx) means everything is
+// allowed, whereas one that is unreachable (5xx or network error) means we
+// should assume everything is disallowed.
+func Fetch(ctx context.Context, protocol string, hosts map[string]bool, httpclient requester.HTTPClient, instr *instrumentator.Instr) *Robots {
+	r := &Robots{hosts: map[string]*Rules{}}
+
+	for host := range hosts {
+		robotsURL := fmt.Sprintf("%s://%s/robots.txt", protocol, host)
+		log := instr.Logger.WithFields(logrus.Fields{"url": robotsURL})
+
+		page, err := requester.Get(ctx, robotsURL, httpclient)
+		switch {
+		case err != nil || page.Status >= 500:
+			log.Warn("ROBOTS_UNREACHABLE")
+			r.hosts[host] = &Rules{rules: []rule{{allow: false, pattern: "/"}}}
+		case page.Status >= 400:
+			r.hosts[host] = &Rules{}
+		default:
+			r.hosts[host] = Parse(page.Body, UserAgent)
+		}
+	}
+
+	return r
+}
+
+// Allowed reports whether the given URL may be requested.
+//
+// A nil *Robots allows everything, which is how the -ignore-robots flag is
+// implemented.
+func (r *Robots) Allowed(rawurl string) bool {
+	if r == nil {
+		return true
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return true
+	}
+
+	rules, ok := r.hosts[u.Host]
+	if !ok {
+		return true
+	}
+
+	return rules.Allowed(u.RequestURI())
+}
+
+// CrawlDelay returns the Crawl-delay requested by the given host (if any).
+func (r *Robots) CrawlDelay(host string) time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	if rules, ok := r.hosts[host]; ok {
+		return rules.CrawlDelay
+	}
+
+	return 0
+}
+
+// Sitemaps returns the sitemap URLs listed by every host's robots.txt.
+func (r *Robots) Sitemaps() []string {
+	if r == nil {
+		return nil
+	}
+
+	var sitemaps []string
+	for _, rules := range r.hosts {
+		sitemaps = append(sitemaps, rules.Sitemaps...)
+	}
+	sort.Strings(sitemaps)
+
+	return sitemaps
+}
+
+// Allowed reports whether the given path (including any query string) may be
+// requested.
+//
+// The most specific (i.e. longest) matching rule wins, and when an Allow and
+// Disallow rule are equally specific the Allow rule wins.
+func (r *Rules) Allowed(path string) bool {
+	allowed := true
+	longest := -1
+
+	for _, rl := range r.rules {
+		if !match(rl.pattern, path) {
+			continue
+		}
+
+		if n := len(rl.pattern); n > longest || (n == longest && rl.allow) {
+			longest = n
+			allowed = rl.allow
+		}
+	}
+
+	return allowed
+}
+
+// Parse extracts the rules from a robots.txt body that apply to the given user
+// agent, falling back to the rules for the `*` user agent.
+func Parse(body []byte, userAgent string) *Rules {
+	groups, sitemaps := parseGroups(body)
+	userAgent = strings.ToLower(userAgent)
+
+	var selected []*group
+	var wildcard []*group
+
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			// note: a group applies to us only when its user agent is exactly our
+			// product token (RFC 9309 §2.2.1), so `User-agent: go` isn't meant for us.
+			//
+			// multiple groups for the same user agent are merged together.
+			switch agent {
+			case "*":
+				wildcard = append(wildcard, g)
+			case userAgent:
+				selected = append(selected, g)
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		selected = wildcard
+	}
+
+	rules := &Rules{Sitemaps: sitemaps}
+	for _, g := range selected {
+		rules.rules = append(rules.rules, g.rules...)
+		if g.crawlDelay > rules.CrawlDelay {
+			rules.CrawlDelay = g.crawlDelay
+		}
+	}
+
+	return rules
+}
+
+// parseGroups splits a robots.txt body into its user-agent groups, along with
+// any Sitemap lines (which can appear anywhere and apply to every group).
+//
+// a group starts with one or more consecutive User-agent lines, and every rule
+// that follows belongs to that group until the next User-agent line is found.
+func parseGroups(body []byte) ([]*group, []string) {
+	var groups []*group
+	var sitemaps []string
+	var current *group
+	inAgents := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			// an empty Disallow means everything is allowed, which is the default
+			if value == "" {
+				continue
+			}
+			current.rules = append(current.rules, rule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			// note: a sitemap line doesn't end the group of user agents, as it's not
+			// part of any group.
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	return groups, sitemaps
+}
+
+// match reports whether the path matches a robots.txt pattern, where `*`
+// matches any sequence of characters and a trailing `$` anchors the pattern
+// to the end of the path.
+func match(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+
+	// the first part must be a prefix of the path (patterns are always matched
+	// from the start of the path).
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+
+		if last && anchored {
+			return strings.HasSuffix(path[pos:], part)
+		}
+
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+
+	if anchored {
+		return pos == len(path)
+	}
+
+	return true
+}
@@ -0,0 +1,120 @@
+package robots
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	body := []byte(`# example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public$
+Disallow: /*.pdf$
+Disallow: /search?q=*
+Crawl-delay: 2
+
+User-agent: Go-Web-Crawler
+User-agent: another-bot
+Disallow: /tags/
+Allow: /tags/go
+Crawl-delay: 0.5
+
+User-agent: go-web-crawler
+Disallow: /drafts
+
+User-agent: go
+User-agent: crawler
+Disallow: /about
+`)
+
+	wildcard := Parse(body, "some-other-bot")
+
+	if wildcard.CrawlDelay != 2*time.Second {
+		t.Errorf("expected: %+v\ngot: %+v", 2*time.Second, wildcard.CrawlDelay)
+	}
+
+	ours := Parse(body, UserAgent)
+
+	if ours.CrawlDelay != 500*time.Millisecond {
+		t.Errorf("expected: %+v\ngot: %+v", 500*time.Millisecond, ours.CrawlDelay)
+	}
+
+	for _, tc := range []struct {
+		rules    *Rules
+		path     string
+		expected bool
+	}{
+		{wildcard, "/", true},
+		{wildcard, "/private/", false},
+		{wildcard, "/private/foo", false},
+		{wildcard, "/private/public", true},
+		{wildcard, "/private/public/foo", false},
+		{wildcard, "/files/report.pdf", false},
+		{wildcard, "/files/report.pdf?download=1", true},
+		{wildcard, "/search?q=golang", false},
+		{wildcard, "/search", true},
+		{ours, "/private/", true},
+		{ours, "/tags/python", false},
+		{ours, "/tags/go", true},
+		{ours, "/drafts/foo", false},
+		{ours, "/about", true},
+	} {
+		if actual := tc.rules.Allowed(tc.path); actual != tc.expected {
+			t.Errorf("%s expected: %+v\ngot: %+v", tc.path, tc.expected, actual)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	r := &Robots{hosts: map[string]*Rules{
+		"www.example.com": Parse([]byte("User-agent: *\nDisallow: /tags/"), UserAgent),
+	}}
+
+	if r.Allowed("https://www.example.com/tags/foo") {
+		t.Errorf("expected /tags/foo to be disallowed")
+	}
+
+	if !r.Allowed("https://www.example.com/posts/foo") {
+		t.Errorf("expected /posts/foo to be allowed")
+	}
+
+	if !r.Allowed("https://other.example.com/tags/foo") {
+		t.Errorf("expected unknown hosts to be allowed")
+	}
+
+	var ignored *Robots
+	if !ignored.Allowed("https://www.example.com/tags/foo") {
+		t.Errorf("expected a nil *Robots to allow everything")
+	}
+}
+
+func TestParseSitemaps(t *testing.T) {
+	body := []byte(`Sitemap: https://www.example.com/sitemap.xml
+User-agent: *
+Sitemap: https://www.example.com/news-sitemap.xml.gz
+Disallow: /private/
+`)
+
+	rules := Parse(body, UserAgent)
+
+	expected := []string{
+		"https://www.example.com/sitemap.xml",
+		"https://www.example.com/news-sitemap.xml.gz",
+	}
+
+	if len(rules.Sitemaps) != len(expected) {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, rules.Sitemaps)
+	}
+
+	for i, v := range rules.Sitemaps {
+		if v != expected[i] {
+			t.Errorf("expected: %+v\ngot: %+v", expected[i], v)
+		}
+	}
+
+	// the sitemap line shouldn't have ended the group of user agents
+	if rules.Allowed("/private/foo") {
+		t.Errorf("expected: %+v\ngot: %+v", false, true)
+	}
+}
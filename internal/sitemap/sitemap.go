@@ -0,0 +1,219 @@
+package sitemap
+
+// The sitemap package discovers and parses a site's existing sitemaps, so that
+// pages which aren't linked to from anywhere can still be crawled (and so that
+// we can report on the differences between the sitemap and the site itself).
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/integralist/go-web-crawler/internal/normalizer"
+	"github.com/integralist/go-web-crawler/internal/requester"
+	"github.com/integralist/go-web-crawler/internal/robots"
+	"github.com/sirupsen/logrus"
+)
+
+// maxSitemaps caps the number of sitemaps we'll request, as a sitemap index can
+// reference other sitemap indexes (and a badly configured site could lead us
+// round in circles).
+const maxSitemaps = 1000
+
+// document represents either a <urlset> or a <sitemapindex> (we don't need to
+// know which it is, as only one of the fields will be populated).
+type document struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// Parse extracts the page URLs from a urlset document, and the sitemap URLs
+// from a sitemapindex document. The body is decompressed first if it's gzipped.
+func Parse(body []byte) (urls []string, sitemaps []string, err error) {
+	// note: we check for the gzip magic number rather than relying on the file
+	// extension or Content-Type, as neither can be trusted.
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		body, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var doc document
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	for _, u := range doc.URLs {
+		if u.Loc != "" {
+			urls = append(urls, u.Loc)
+		}
+	}
+
+	for _, s := range doc.Sitemaps {
+		if s.Loc != "" {
+			sitemaps = append(sitemaps, s.Loc)
+		}
+	}
+
+	return urls, sitemaps, nil
+}
+
+// Discover returns every page URL listed in the site's sitemaps.
+//
+// The sitemaps are found via the Sitemap lines in robots.txt along with the
+// conventional /sitemap.xml location, and any sitemap index is followed to the
+// sitemaps it references.
+func Discover(ctx context.Context, protocol, hostname string, robotsRules *robots.Robots, httpclient requester.HTTPClient, instr *instrumentator.Instr) []string {
+	queue := append(robotsRules.Sitemaps(), fmt.Sprintf("%s://%s/sitemap.xml", protocol, hostname))
+
+	requested := map[string]bool{}
+	found := map[string]bool{}
+	var urls []string
+
+	for len(queue) > 0 && len(requested) < maxSitemaps && ctx.Err() == nil {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+
+		if requested[sitemapURL] {
+			continue
+		}
+		requested[sitemapURL] = true
+
+		log := instr.Logger.WithFields(logrus.Fields{"url": sitemapURL})
+
+		page, err := requester.Get(ctx, sitemapURL, httpclient)
+		if err != nil || page.Status != 200 {
+			log.Debug("SITEMAP_UNAVAILABLE")
+			continue
+		}
+
+		pageURLs, sitemaps, err := Parse(page.Body)
+		if err != nil {
+			log.Warn("SITEMAP_INVALID")
+			continue
+		}
+
+		for _, u := range pageURLs {
+			if !found[u] {
+				found[u] = true
+				urls = append(urls, u)
+			}
+		}
+
+		queue = append(queue, sitemaps...)
+	}
+
+	return urls
+}
+
+// Orphans compares the pages listed in the sitemap against the pages that can
+// be reached by following links from the entry page.
+//
+// orphans are listed in the sitemap but can't be reached by following links
+// from the entry page, whereas unlisted pages can be reached (and responded with
+// a 200) but are missing from the sitemap. listed is expected to contain
+// normalized URLs.
+func Orphans(results []mapper.Page, listed []string, entry string) (orphans []string, unlisted []string) {
+	linked := reachable(results, entry)
+
+	inSitemap := map[string]bool{}
+	for _, url := range listed {
+		inSitemap[url] = true
+		if !linked[url] {
+			orphans = append(orphans, url)
+		}
+	}
+
+	for _, page := range results {
+		// note: a URL that redirected to a page we crawled separately isn't a page
+		// (the page it redirected to is reported instead).
+		if page.Error != "" || page.Status != 200 || page.FinalURL != "" {
+			continue
+		}
+
+		// a redirected page is linked to (and may be listed) using the URL that
+		// was originally requested rather than the URL it was served from.
+		urls := []string{page.URL}
+		if len(page.Redirects) > 0 {
+			urls = append(urls, normalizer.String(page.Redirects[0].URL))
+		}
+
+		var isLinked, isListed bool
+		for _, url := range urls {
+			isLinked = isLinked || linked[url]
+			isListed = isListed || inSitemap[url]
+		}
+
+		if isLinked && !isListed {
+			unlisted = append(unlisted, page.URL)
+		}
+	}
+
+	sort.Strings(orphans)
+	sort.Strings(unlisted)
+
+	return orphans, unlisted
+}
+
+// reachable returns the URLs that can be reached by following the anchors of
+// the crawled pages from the entry page.
+//
+// note: the seeds are crawled too, and so we can't simply use every crawled
+// page's anchors, as orphan pages linking to each other would count as linked.
+func reachable(results []mapper.Page, entry string) map[string]bool {
+	// a redirected page is linked to using the URL that was originally requested
+	// rather than the URL it was served from, so it's known by both.
+	pages := map[string]mapper.Page{}
+	for _, page := range results {
+		pages[page.URL] = page
+		if len(page.Redirects) > 0 {
+			pages[normalizer.String(page.Redirects[0].URL)] = page
+		}
+	}
+
+	linked := map[string]bool{}
+	queue := []string{entry}
+
+	for len(queue) > 0 {
+		url := queue[0]
+		queue = queue[1:]
+
+		if linked[url] {
+			continue
+		}
+		linked[url] = true
+
+		page, ok := pages[url]
+		if !ok {
+			continue
+		}
+
+		// a URL that redirected to a page we crawled separately leads to that page.
+		queue = append(queue, page.URL)
+		if page.FinalURL != "" {
+			queue = append(queue, page.FinalURL)
+		}
+
+		for _, anchor := range page.Anchors {
+			queue = append(queue, normalizer.String(anchor))
+		}
+	}
+
+	return linked
+}
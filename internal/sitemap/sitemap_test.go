@@ -0,0 +1,152 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/instrumentator"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+	"github.com/sirupsen/logrus"
+)
+
+const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://www.example.com/</loc><lastmod>2020-01-01</lastmod></url>
+  <url><loc>http://www.example.com/a?b=1&amp;c=2</loc></url>
+</urlset>`
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"plain":   []byte(urlset),
+		"gzipped": gzipped(urlset),
+	} {
+		urls, sitemaps, err := Parse(body)
+		if err != nil {
+			t.Fatalf("%s unexpected error: %s", name, err)
+		}
+
+		expected := []string{"http://www.example.com/", "http://www.example.com/a?b=1&c=2"}
+
+		if strings.Join(urls, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s expected: %+v\ngot: %+v", name, expected, urls)
+		}
+
+		if len(sitemaps) != 0 {
+			t.Errorf("%s expected no sitemaps\ngot: %+v", name, sitemaps)
+		}
+	}
+
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://www.example.com/sitemap-1.xml</loc></sitemap>
+  <sitemap><loc>http://www.example.com/sitemap-2.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+	urls, sitemaps, err := Parse([]byte(index))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(urls) != 0 || len(sitemaps) != 2 || sitemaps[1] != "http://www.example.com/sitemap-2.xml.gz" {
+		t.Errorf("expected: %+v\ngot: %+v %+v", "two sitemaps", urls, sitemaps)
+	}
+
+	if _, _, err := Parse([]byte("not xml")); err == nil {
+		t.Errorf("expected: an error\ngot: %+v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/pages.xml.gz</loc></sitemap><sitemap><loc>%s/sitemap.xml</loc></sitemap></sitemapindex>`, server.URL, server.URL)
+		case "/pages.xml.gz":
+			w.Write(gzipped(fmt.Sprintf(`<urlset><url><loc>%s/a</loc></url><url><loc>%s/b</loc></url></urlset>`, server.URL, server.URL)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	instr := instrumentator.Instr{
+		Logger: logrus.NewEntry(logrus.New()),
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	actual := Discover(context.Background(), "http", host, nil, http.DefaultClient, &instr)
+	expected := []string{server.URL + "/a", server.URL + "/b"}
+
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected: %+v\ngot: %+v", expected, actual)
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	results := []mapper.Page{
+		{URL: "http://www.example.com/", Status: 200, Anchors: mapper.Assets{"http://www.example.com/a", "http://www.example.com/b"}},
+		{URL: "http://www.example.com/a", Status: 200},
+		{URL: "http://www.example.com/b", Status: 200},
+		{URL: "http://www.example.com/hidden", Status: 200},
+	}
+
+	listed := []string{
+		"http://www.example.com/",
+		"http://www.example.com/a",
+		"http://www.example.com/hidden",
+	}
+
+	orphans, unlisted := Orphans(results, listed, "http://www.example.com/")
+
+	if len(orphans) != 1 || orphans[0] != "http://www.example.com/hidden" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/hidden"}, orphans)
+	}
+
+	if len(unlisted) != 1 || unlisted[0] != "http://www.example.com/b" {
+		t.Errorf("expected: %+v\ngot: %+v", []string{"http://www.example.com/b"}, unlisted)
+	}
+}
+
+func TestOrphansLinkingToEachOther(t *testing.T) {
+	// the orphans were crawled because they're listed in the sitemap, but as
+	// they only link to each other they can't be reached from the entry page.
+	results := []mapper.Page{
+		{URL: "http://www.example.com/", Status: 200, Anchors: mapper.Assets{"http://www.example.com/old"}},
+		{URL: "http://www.example.com/old", FinalURL: "http://www.example.com/new", Status: 200},
+		{URL: "http://www.example.com/new", Status: 200},
+		{URL: "http://www.example.com/x", Status: 200, Anchors: mapper.Assets{"http://www.example.com/y"}},
+		{URL: "http://www.example.com/y", Status: 200, Anchors: mapper.Assets{"http://www.example.com/x"}},
+	}
+
+	listed := []string{
+		"http://www.example.com/",
+		"http://www.example.com/new",
+		"http://www.example.com/x",
+		"http://www.example.com/y",
+	}
+
+	orphans, unlisted := Orphans(results, listed, "http://www.example.com/")
+
+	expected := []string{"http://www.example.com/x", "http://www.example.com/y"}
+	if len(orphans) != 2 || orphans[0] != expected[0] || orphans[1] != expected[1] {
+		t.Errorf("expected: %+v\ngot: %+v", expected, orphans)
+	}
+
+	if len(unlisted) != 0 {
+		t.Errorf("expected: %+v\ngot: %+v", []string{}, unlisted)
+	}
+}
@@ -0,0 +1,230 @@
+package state
+
+// The state package persists the progress of a crawl to disk, so that a crawl
+// which is interrupted (or crashes) can be resumed rather than restarted.
+//
+// The state is an append-only log of JSON records (one per line), which keeps
+// writes cheap and means a crash can at worst leave a partially written final
+// record (which is discarded when the log is replayed).
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/integralist/go-web-crawler/internal/frontier"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+// filename is the name of the log file within the state directory.
+const filename = "state.jsonl"
+
+// the types of record written to the log.
+const (
+	queued = "queued"
+	done   = "done"
+)
+
+// record is a single line within the log.
+//
+// note: a processed URL and its result are written as a single record, so that
+// a crash can't leave a URL marked as done without its result (or vice versa).
+type record struct {
+	Type  string
+	URL   string
+	Depth int          `json:",omitempty"`
+	Bytes int64        `json:",omitempty"`
+	Page  *mapper.Page `json:",omitempty"`
+}
+
+// Store tracks which URLs have been seen, queued and processed (along with the
+// results), and is safe for concurrent use.
+//
+// Store implements crawler.Tracker, meaning it can be used in place of the
+// in-memory sync.Map that tracks URLs.
+//
+// note: tracking a URL isn't recorded in the log by itself, instead a URL is
+// considered tracked when the log is replayed if it was either queued or
+// processed. Otherwise a crash between tracking a URL and queueing it would
+// mean the URL is never crawled once the crawl is resumed.
+type Store struct {
+	mutex   sync.Mutex
+	file    *os.File
+	seen    sync.Map
+	queue   []frontier.Item
+	done    map[string]bool
+	results []mapper.Page
+	bytes   int64
+	resumed bool
+}
+
+// Open opens the log within the given directory (creating the directory if
+// necessary).
+//
+// When resume is true the existing log is replayed so the crawl can pick up
+// where it left off, otherwise any existing log is discarded.
+func Open(dir string, resume bool) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_RDWR
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, filename), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{file: file, done: map[string]bool{}}
+
+	if resume {
+		if err := s.replay(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// replay rebuilds the state from the log, and then truncates any partially
+// written record so that new records are appended after the last valid one.
+func (s *Store) replay() error {
+	var offset int64
+
+	reader := bufio.NewReader(s.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var r record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &r); err != nil {
+			break
+		}
+
+		s.apply(r)
+		offset += int64(len(line))
+	}
+
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.resumed = len(s.done) > 0
+	return nil
+}
+
+// apply updates the in-memory state with a record from the log.
+func (s *Store) apply(r record) {
+	switch r.Type {
+	case queued:
+		s.seen.Store(r.URL, true)
+		s.queue = append(s.queue, frontier.Item{URL: r.URL, Depth: r.Depth})
+	case done:
+		s.seen.Store(r.URL, true)
+		s.done[r.URL] = true
+		s.bytes += r.Bytes
+		if r.Page != nil {
+			// a redirected page is tracked under the URL it was served from
+			s.seen.Store(r.Page.URL, true)
+			s.results = append(s.results, *r.Page)
+		}
+	}
+}
+
+// write appends a record to the log.
+func (s *Store) write(r record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+// Resumed reports whether a previous crawl was found in the log.
+func (s *Store) Resumed() bool {
+	return s.resumed
+}
+
+// Load returns whether the given URL has been tracked.
+func (s *Store) Load(key interface{}) (value interface{}, ok bool) {
+	return s.seen.Load(key)
+}
+
+// LoadOrStore tracks the given URL, returning whether it was already tracked.
+func (s *Store) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	return s.seen.LoadOrStore(key, value)
+}
+
+// Store tracks the given URL.
+func (s *Store) Store(key, value interface{}) {
+	s.seen.Store(key, value)
+}
+
+// Queued records that an item was pushed onto the frontier.
+func (s *Store) Queued(item frontier.Item) error {
+	return s.write(record{Type: queued, URL: item.URL, Depth: item.Depth})
+}
+
+// Done records that a URL was processed, along with the number of bytes that
+// were downloaded and its result (a nil page means the URL was processed but
+// isn't part of the results, e.g. it redirected to a page already tracked).
+func (s *Store) Done(url string, bytes int64, page *mapper.Page) error {
+	return s.write(record{Type: done, URL: url, Bytes: bytes, Page: page})
+}
+
+// Pending returns the items that were queued but never processed, in the order
+// they were originally queued.
+func (s *Store) Pending() []frontier.Item {
+	var pending []frontier.Item
+	for _, item := range s.queue {
+		if !s.done[item.URL] {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+// Results returns the results of every URL processed by the previous crawl.
+func (s *Store) Results() []mapper.Page {
+	return s.results
+}
+
+// Processed returns the number of URLs processed by the previous crawl, along
+// with the total number of bytes downloaded.
+func (s *Store) Processed() (int, int64) {
+	return len(s.done), s.bytes
+}
+
+// Close flushes the log to disk and closes it.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
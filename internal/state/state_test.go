@@ -0,0 +1,126 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/integralist/go-web-crawler/internal/frontier"
+	"github.com/integralist/go-web-crawler/internal/mapper"
+)
+
+func TestResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.Resumed() {
+		t.Errorf("expected: %+v\ngot: %+v", false, true)
+	}
+
+	entry := mapper.Page{URL: "http://www.example.com/", Status: 200}
+	store.Queued(frontier.Item{URL: "http://www.example.com/a", Depth: 1})
+	store.Queued(frontier.Item{URL: "http://www.example.com/b", Depth: 1})
+	store.Done("http://www.example.com/", 100, &entry)
+
+	// a redirected page is recorded under the URL it was served from
+	redirected := mapper.Page{URL: "http://www.example.com/c", Status: 200, Depth: 1}
+	store.Done("http://www.example.com/a", 50, &redirected)
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash part way through writing a record
+	f, err := os.OpenFile(filepath.Join(dir, filename), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Type":"done","URL":"http://www.exa`)
+	f.Close()
+
+	store, err = Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.Resumed() {
+		t.Errorf("expected: %+v\ngot: %+v", true, false)
+	}
+
+	pending := store.Pending()
+	if len(pending) != 1 || pending[0] != (frontier.Item{URL: "http://www.example.com/b", Depth: 1}) {
+		t.Errorf("expected: %+v\ngot: %+v", "http://www.example.com/b", pending)
+	}
+
+	if results := store.Results(); len(results) != 2 || results[1].URL != redirected.URL {
+		t.Errorf("expected: %+v\ngot: %+v", []mapper.Page{entry, redirected}, results)
+	}
+
+	if pages, bytes := store.Processed(); pages != 2 || bytes != 150 {
+		t.Errorf("expected: %+v %+v\ngot: %+v %+v", 2, 150, pages, bytes)
+	}
+
+	for _, url := range []string{"http://www.example.com/", "http://www.example.com/a", "http://www.example.com/b", "http://www.example.com/c"} {
+		if _, ok := store.Load(url); !ok {
+			t.Errorf("expected: %s to be tracked", url)
+		}
+	}
+
+	// records written after resuming must follow the last valid record
+	store.Done("http://www.example.com/b", 25, nil)
+	store.Close()
+
+	store, err = Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if pending := store.Pending(); len(pending) != 0 {
+		t.Errorf("expected: no pending items\ngot: %+v", pending)
+	}
+
+	if pages, bytes := store.Processed(); pages != 3 || bytes != 175 {
+		t.Errorf("expected: %+v %+v\ngot: %+v %+v", 3, 175, pages, bytes)
+	}
+}
+
+func TestOpenWithoutResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Done("http://www.example.com/", 100, &mapper.Page{URL: "http://www.example.com/"})
+	store.Close()
+
+	store, err = Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if store.Resumed() {
+		t.Errorf("expected: %+v\ngot: %+v", false, true)
+	}
+}